@@ -0,0 +1,220 @@
+package loge
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+type CachePolicy int
+
+const (
+	// Drop objects as soon as no transaction references them
+	CACHE_TRANSIENT CachePolicy = iota
+	// Keep objects forever once loaded
+	CACHE_PERMANENT
+	// Keep recently-used objects, up to the DB's cache size
+	CACHE_LRU
+)
+
+const db_DEFAULT_CACHE_SIZE = 10000
+const db_CACHE_SHARDS = 64
+
+type CacheStats struct {
+	Hits uint64
+	Misses uint64
+	Evictions uint64
+	Size int
+	Retained int
+}
+
+// Objects by cache key, split so transactions on unrelated keys don't
+// queue on one lock. A shard's lock also covers its objects' refcounts
+// and version chains.
+type objCache [db_CACHE_SHARDS]*cacheShard
+
+type cacheShard struct {
+	lock sync.Mutex
+	objects map[string]*logeObject
+	// Latest snapshot of objects dropped while older transactions were
+	// open, which could still load and commit them
+	released map[string]uint64
+}
+
+// Taken inside shard locks, never the other way round
+type objectLRU struct {
+	lock sync.Mutex
+	capacity int
+	order *list.List
+	entries map[*logeObject]*list.Element
+}
+
+func newObjectLRU(capacity int) *objectLRU {
+	return &objectLRU{
+		capacity: capacity,
+		order: list.New(),
+		entries: make(map[*logeObject]*list.Element),
+	}
+}
+
+func (lru *objectLRU) push(obj *logeObject) {
+	if el, ok := lru.entries[obj]; ok {
+		lru.order.MoveToFront(el)
+		return
+	}
+	lru.entries[obj] = lru.order.PushFront(obj)
+}
+
+func (lru *objectLRU) remove(obj *logeObject) {
+	if el, ok := lru.entries[obj]; ok {
+		lru.order.Remove(el)
+		delete(lru.entries, obj)
+	}
+}
+
+func (lru *objectLRU) popOverflow() []*logeObject {
+	var evicted []*logeObject
+	for lru.order.Len() > lru.capacity {
+		var el = lru.order.Back()
+		var obj = el.Value.(*logeObject)
+		lru.order.Remove(el)
+		delete(lru.entries, obj)
+		evicted = append(evicted, obj)
+	}
+	return evicted
+}
+
+func (lru *objectLRU) len() int {
+	return lru.order.Len()
+}
+
+func (lru *objectLRU) has(obj *logeObject) bool {
+	var _, ok = lru.entries[obj]
+	return ok
+}
+
+
+func newObjCache() *objCache {
+	var cache = &objCache{}
+	for i := range cache {
+		cache[i] = &cacheShard{
+			objects: make(map[string]*logeObject),
+			released: make(map[string]uint64),
+		}
+	}
+	return cache
+}
+
+func (cache *objCache) shard(cacheKey string) *cacheShard {
+	var hash = fnv.New32a()
+	hash.Write([]byte(cacheKey))
+	return cache[hash.Sum32() % db_CACHE_SHARDS]
+}
+
+func (cache *objCache) get(cacheKey string) (*logeObject, bool) {
+	var shard = cache.shard(cacheKey)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	var obj, ok = shard.objects[cacheKey]
+	return obj, ok
+}
+
+func (cache *objCache) size() int {
+	var size = 0
+	for _, shard := range cache {
+		shard.lock.Lock()
+		size += len(shard.objects)
+		shard.lock.Unlock()
+	}
+	return size
+}
+
+
+// -----------------------------------------------
+// LogeDB cache management
+// -----------------------------------------------
+
+func (db *LogeDB) SetCacheSize(size int) {
+	db.lru.lock.Lock()
+	db.lru.capacity = size
+	var evicted = db.lru.popOverflow()
+	db.lru.lock.Unlock()
+
+	db.evictObjects(evicted)
+}
+
+func (db *LogeDB) CacheStats() CacheStats {
+	var size = db.cache.size()
+
+	db.lru.lock.Lock()
+	defer db.lru.lock.Unlock()
+
+	return CacheStats{
+		Hits: atomic.LoadUint64(&db.cacheHits),
+		Misses: atomic.LoadUint64(&db.cacheMisses),
+		Evictions: atomic.LoadUint64(&db.cacheEvictions),
+		Size: size,
+		Retained: db.lru.len(),
+	}
+}
+
+// Called with the shard lock held, once the object's refcount drops to
+// zero. Returns anything pushed out of the LRU, to go to evictObjects
+// once the shard is unlocked.
+func (db *LogeDB) retainObject(obj *logeObject) []*logeObject {
+	if obj.invalid {
+		db.uncacheObject(obj)
+		return nil
+	}
+
+	// Open snapshots may still need older versions, and reading them
+	// relies on every commit since cachedAt being in the chain
+	switch obj.Type.CachePolicy {
+	case CACHE_PERMANENT:
+		obj.pruneVersions(db.activeSnapshots())
+	case CACHE_LRU:
+		obj.pruneVersions(db.activeSnapshots())
+		db.lru.lock.Lock()
+		defer db.lru.lock.Unlock()
+		db.lru.push(obj)
+		return db.lru.popOverflow()
+	default:
+		db.uncacheObject(obj)
+	}
+	return nil
+}
+
+// Called with the shard lock held
+func (db *LogeDB) uncacheObject(obj *logeObject) {
+	var cacheKey = obj.makeObjRef().CacheKey
+	if obj.shard.objects[cacheKey] == obj {
+		delete(obj.shard.objects, cacheKey)
+		if obj.Current != nil && obj.Current.snapshotID > db.OldestSnapshotID() {
+			obj.shard.released[cacheKey] = obj.Current.snapshotID
+		}
+	}
+	if obj.Type.CachePolicy == CACHE_LRU {
+		db.lru.lock.Lock()
+		db.lru.remove(obj)
+		db.lru.lock.Unlock()
+	}
+}
+
+// Objects picked up again since leaving the LRU stay cached
+func (db *LogeDB) evictObjects(evicted []*logeObject) {
+	for _, obj := range evicted {
+		obj.shard.lock.Lock()
+
+		db.lru.lock.Lock()
+		var reused = obj.RefCount > 0 || db.lru.has(obj)
+		db.lru.lock.Unlock()
+
+		if !reused {
+			db.uncacheObject(obj)
+			atomic.AddUint64(&db.cacheEvictions, 1)
+			db.metrics.Count(metric_CACHE_EVICTIONS, 1)
+		}
+		obj.shard.lock.Unlock()
+	}
+}
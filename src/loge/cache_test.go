@@ -0,0 +1,152 @@
+package loge
+
+import (
+	"testing"
+	"strconv"
+)
+
+func TestCacheRetention(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.CachePolicy = CACHE_LRU
+	db.CreateType(def)
+
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var before = db.CacheStats()
+
+	if before.Retained != 1 {
+		test.Errorf("Object not retained after commit: %d", before.Retained)
+	}
+
+	if db.ReadOne("test", "one").(*TestObj).Name != "One" {
+		test.Error("Wrong name from retained object")
+	}
+
+	var after = db.CacheStats()
+	if after.Hits != before.Hits + 1 || after.Misses != before.Misses {
+		test.Errorf("Read of retained object missed cache: %v / %v", before, after)
+	}
+}
+
+func TestCacheTransient(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var stats = db.CacheStats()
+	if stats.Size != 0 || stats.Retained != 0 {
+		test.Errorf("Transient object retained: %v", stats)
+	}
+}
+
+func TestCacheEviction(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.CachePolicy = CACHE_LRU
+	db.CreateType(def)
+	db.SetCacheSize(5)
+
+	for i := 0; i < 10; i++ {
+		var key = strconv.Itoa(i)
+		db.SetOne("test", LogeKey(key), &TestObj{Name: key})
+	}
+
+	var stats = db.CacheStats()
+	if stats.Retained != 5 || stats.Size != 5 {
+		test.Errorf("Wrong cache size after overflow: %v", stats)
+	}
+	if stats.Evictions != 5 {
+		test.Errorf("Wrong eviction count: %d", stats.Evictions)
+	}
+
+	for i := 0; i < 10; i++ {
+		var key = strconv.Itoa(i)
+		if db.ReadOne("test", LogeKey(key)).(*TestObj).Name != key {
+			test.Errorf("Wrong object after eviction: %s", key)
+		}
+	}
+
+	db.SetCacheSize(2)
+	if db.CacheStats().Retained != 2 {
+		test.Errorf("Cache not shrunk: %v", db.CacheStats())
+	}
+}
+
+func TestCacheUpdateScoping(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.CachePolicy = CACHE_PERMANENT
+	db.CreateType(def)
+
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var trans1 = db.CreateTransaction()
+
+	db.Transact(func (t *Transaction) {
+		t.Write("test", "one").(*TestObj).Name = "Two"
+	}, 0)
+
+	if trans1.Read("test", "one").(*TestObj).Name != "One" {
+		test.Error("Retained object leaked later version")
+	}
+
+	if db.ReadOne("test", "one").(*TestObj).Name != "Two" {
+		test.Error("Retained object not updated by commit")
+	}
+	trans1.Cancel()
+}
+
+func TestCacheReleasedConflict(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	// Opened before the update, but only loads the object after it has
+	// been dropped from the cache
+	var stale = db.CreateTransaction()
+
+	db.Transact(func (t *Transaction) {
+		t.Write("test", "one").(*TestObj).Name = "Two"
+	}, 0)
+
+	if db.CacheStats().Size != 0 {
+		test.Errorf("Transient object retained: %v", db.CacheStats())
+	}
+
+	stale.Write("test", "one").(*TestObj).Name = "Stale"
+	if stale.Commit() {
+		test.Error("Stale transaction committed over dropped update")
+	}
+
+	if db.ReadOne("test", "one").(*TestObj).Name != "Two" {
+		test.Error("Update lost")
+	}
+}
+
+// Retained objects keep the versions open snapshots can see
+func TestCacheRetainedSnapshots(test *testing.T) {
+	for _, policy := range []CachePolicy{ CACHE_PERMANENT, CACHE_LRU } {
+		var db = NewLogeDB(NewMemStore())
+		var def = NewTypeDef("test", 1, &TestObj{})
+		def.CachePolicy = policy
+		db.CreateType(def)
+
+		db.SetOne("test", "x", &TestObj{Name: "0"})
+		var ta = db.CreateTransaction()
+		db.SetOne("test", "x", &TestObj{Name: "1"})
+		var tb = db.CreateTransaction()
+		db.SetOne("test", "x", &TestObj{Name: "2"})
+
+		if name := ta.Read("test", "x").(*TestObj).Name; name != "0" {
+			test.Errorf("Policy %d: first snapshot read %s", policy, name)
+		}
+		if name := tb.Read("test", "x").(*TestObj).Name; name != "1" {
+			test.Errorf("Policy %d: second snapshot read %s", policy, name)
+		}
+		ta.Cancel()
+		tb.Cancel()
+	}
+}
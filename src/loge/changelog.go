@@ -0,0 +1,238 @@
+package loge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Prune once this fraction of the retention window has expired
+const ldb_CHANGE_PRUNE_FRACTION = 8
+
+var ErrNoChangeLog = errors.New("store keeps no change log")
+var ErrChangesPruned = errors.New("changes since snapshot already pruned")
+
+var errChangeCorrupt = errors.New("corrupt change record")
+
+// Committed changes after snapshot sID, oldest first. Whole commits
+// only, stopping once there are at least limit changes (-1 for all),
+// and never past an unfinished commit, so the last SnapshotID seen is
+// always safe to resume from.
+func (db *LogeDB) ChangesSince(sID uint64, limit int) ([]ChangeEvent, error) {
+	if db.changeLog == nil {
+		return nil, ErrNoChangeLog
+	}
+
+	db.snapshotLock.Lock()
+	var upTo = db.completedSnapshot()
+	db.snapshotLock.Unlock()
+
+	var changes, err = db.changeLog.ChangesSince(sID, upTo, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range changes {
+		changes[i].typ = db.types[changes[i].TypeName]
+		changes[i].db = db
+	}
+	return changes, nil
+}
+
+
+// -----------------------------------------------
+// LevelDB change log
+// -----------------------------------------------
+
+func (store *levelDBStore) KeepsChanges() bool {
+	return store.changeLog
+}
+
+func (store *levelDBStore) LastSnapshotID() uint64 {
+	return store.lastLogged
+}
+
+func (store *levelDBStore) ChangesSince(sID uint64, upTo uint64, limit int) ([]ChangeEvent, error) {
+	var changes = make([]ChangeEvent, 0)
+	if limit == 0 {
+		return changes, nil
+	}
+
+	var prefix = encodeTaggedKey([]uint16{ ldb_CHANGE_TAG }, "")
+	var it = store.engine.newIterator()
+	defer it.Close()
+
+	// Checked after the iterator is taken, and pruning marks before it
+	// deletes, so nothing goes missing unannounced
+	if sID < atomic.LoadUint64(&store.prunedThrough) {
+		return nil, ErrChangesPruned
+	}
+
+	for it.Seek(encodeChangeKey(sID + 1, 0)); it.Valid() && bytes.HasPrefix(it.Key(), prefix); it.Next() {
+		var event, err = decodeChange(it.Key(), it.Value())
+		if err != nil {
+			return nil, err
+		}
+		if event.SnapshotID > upTo {
+			break
+		}
+		if limit > 0 && len(changes) >= limit &&
+			event.SnapshotID != changes[len(changes) - 1].SnapshotID {
+			break
+		}
+		changes = append(changes, event)
+	}
+
+	return changes, nil
+}
+
+func (store *levelDBStore) loadChangeLog() {
+	store.lastLogged = store.changeMarker("last")
+	store.prunedThrough = store.changeMarker("pruned")
+}
+
+func (store *levelDBStore) changeMarker(name string) uint64 {
+	var val, err = store.engine.get(encodeTaggedKey([]uint16{ ldb_CHANGE_INFO_TAG }, name))
+	if err != nil {
+		panic(fmt.Sprintf("Read error: %v\n", err))
+	}
+	if len(val) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(val)
+}
+
+func changeMarkerEntry(name string, sID uint64) levelDBWriteEntry {
+	var val = make([]byte, 8)
+	binary.BigEndian.PutUint64(val, sID)
+	return levelDBWriteEntry{ encodeTaggedKey([]uint16{ ldb_CHANGE_INFO_TAG }, name), val, false }
+}
+
+// Recorded for every commit, logged changes or not, so snapshot IDs
+// (kept by the change log and history) never repeat. Called from the
+// writer, which alone updates lastLogged.
+func (store *levelDBStore) markLogged(batch []*levelDBContext) []levelDBWriteEntry {
+	var last = store.lastLogged
+	for _, context := range batch {
+		if context.commitID > last {
+			last = context.commitID
+		}
+	}
+	if last == store.lastLogged {
+		return nil
+	}
+	store.lastLogged = last
+	return []levelDBWriteEntry{ changeMarkerEntry("last", last) }
+}
+
+// Deletes changes which have left the retention window, once enough
+// have built up to be worth a pass. Called from the writer.
+func (store *levelDBStore) pruneChanges() {
+	var retention = store.changeRetention
+	if retention == 0 || store.lastLogged <= retention {
+		return
+	}
+
+	var cutoff = store.lastLogged - retention
+	var pruned = atomic.LoadUint64(&store.prunedThrough)
+	if cutoff < pruned + retention / ldb_CHANGE_PRUNE_FRACTION + 1 {
+		return
+	}
+
+	var entries = make([]levelDBWriteEntry, 0)
+	var it = iteratePrefix(store.engine, encodeTaggedKey([]uint16{ ldb_CHANGE_TAG }, ""), []byte{})
+	for ; it.Valid(); it.Next() {
+		var key = it.Key()
+		if binary.BigEndian.Uint64(key[2:10]) > cutoff {
+			break
+		}
+		entries = append(entries, levelDBWriteEntry{ append([]byte(nil), key...), nil, true })
+	}
+	it.Close()
+
+	atomic.StoreUint64(&store.prunedThrough, cutoff)
+	entries = append(entries, changeMarkerEntry("pruned", cutoff))
+
+	var err = store.engine.write(entries)
+	if err != nil {
+		store.logger.Error("Pruning change log failed", "error", err)
+		return
+	}
+	store.logger.Debug("Pruned change log", "changes", len(entries) - 1, "snapshot", cutoff)
+}
+
+func (context *levelDBContext) LogChange(event ChangeEvent) {
+	context.put(encodeChangeKey(event.SnapshotID, context.changes), encodeChange(event))
+	context.changes++
+}
+
+
+// -----------------------------------------------
+// Change encoding
+// -----------------------------------------------
+
+// Snapshot, then position within the commit
+func encodeChangeKey(sID uint64, seq uint32) []byte {
+	var key = make([]byte, 14)
+	binary.BigEndian.PutUint16(key[0:2], ldb_CHANGE_TAG)
+	binary.BigEndian.PutUint64(key[2:10], sID)
+	binary.BigEndian.PutUint32(key[10:14], seq)
+	return key
+}
+
+// Blob lengths are stored plus one, so zero means nil
+func encodeChange(event ChangeEvent) []byte {
+	var val = make([]byte, 0, 16 + len(event.OldBlob) + len(event.NewBlob))
+	for _, field := range []string{ event.TypeName, string(event.Key), event.LinkName } {
+		val = binary.AppendUvarint(val, uint64(len(field)))
+		val = append(val, field...)
+	}
+	for _, blob := range [][]byte{ event.OldBlob, event.NewBlob } {
+		if blob == nil {
+			val = binary.AppendUvarint(val, 0)
+			continue
+		}
+		val = binary.AppendUvarint(val, uint64(len(blob)) + 1)
+		val = append(val, blob...)
+	}
+	return val
+}
+
+func decodeChange(key []byte, val []byte) (ChangeEvent, error) {
+	var event ChangeEvent
+	if len(key) != 14 {
+		return event, errChangeCorrupt
+	}
+	event.SnapshotID = binary.BigEndian.Uint64(key[2:10])
+
+	var fields [5][]byte
+	for i := range fields {
+		var length, n = binary.Uvarint(val)
+		if n <= 0 {
+			return event, errChangeCorrupt
+		}
+		val = val[n:]
+
+		// Strings first, then nil-able blobs
+		if i >= 3 {
+			if length == 0 {
+				continue
+			}
+			length--
+		}
+		if length > uint64(len(val)) {
+			return event, errChangeCorrupt
+		}
+		fields[i] = append([]byte{}, val[:length]...)
+		val = val[length:]
+	}
+
+	event.TypeName = string(fields[0])
+	event.Key = LogeKey(fields[1])
+	event.LinkName = string(fields[2])
+	event.OldBlob = fields[3]
+	event.NewBlob = fields[4]
+	return event, nil
+}
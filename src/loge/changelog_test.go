@@ -0,0 +1,124 @@
+package loge
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestChangesSince(test *testing.T) {
+	var dir = test.TempDir()
+	var options = DefaultLevelDBOptions()
+	options.ChangeLog = true
+
+	var db = openStoreTest(NewGoLevelDBStoreWithOptions(dir, options))
+
+	db.Transact(func (t *Transaction) {
+		t.Set("test", "one", &TestObj{ "One" })
+		t.Set("test", "two", &TestObj{ "Two" })
+	}, 0)
+	db.Transact(func (t *Transaction) {
+		t.Write("test", "one").(*TestObj).Name = "Changed"
+		t.AddLink("test", "other", "one", "two")
+	}, 0)
+	db.DeleteOne("test", "two")
+
+	var changes, err = db.ChangesSince(0, -1)
+	if err != nil {
+		test.Fatalf("ChangesSince error: %v", err)
+	}
+	if len(changes) != 5 {
+		test.Fatalf("Wrong change count: %d", len(changes))
+	}
+	if changes[0].Key != "one" || changes[0].Old() != nil || changes[0].New().(*TestObj).Name != "One" {
+		test.Errorf("Wrong first change: %v", changes[0])
+	}
+	if last := changes[4]; last.Key != "two" || last.Old().(*TestObj).Name != "Two" || last.New() != nil {
+		test.Errorf("Wrong delete change: %v", last)
+	}
+	for i := 1; i < len(changes); i++ {
+		if changes[i].SnapshotID < changes[i-1].SnapshotID {
+			test.Errorf("Changes out of order: %d after %d", changes[i].SnapshotID, changes[i-1].SnapshotID)
+		}
+	}
+
+	// Limits never split a commit
+	var limited, _ = db.ChangesSince(0, 1)
+	if len(limited) != 2 || limited[0].SnapshotID != limited[1].SnapshotID {
+		test.Errorf("Limit split a commit: %v", limited)
+	}
+
+	var cursor = changes[len(changes) - 1].SnapshotID
+	if rest, _ := db.ChangesSince(cursor, -1); len(rest) != 0 {
+		test.Errorf("Changes after cursor: %v", rest)
+	}
+
+	db.Close()
+	db = openStoreTest(NewGoLevelDBStoreWithOptions(dir, options))
+	defer db.Close()
+
+	db.SetOne("test", "three", &TestObj{ "Three" })
+
+	var resumed, _ = db.ChangesSince(cursor, -1)
+	if len(resumed) != 1 || resumed[0].Key != "three" || resumed[0].SnapshotID <= cursor {
+		test.Errorf("Wrong changes after reopen: %v", resumed)
+	}
+
+	if all, _ := db.ChangesSince(0, -1); len(all) != 6 {
+		test.Errorf("Log lost changes on reopen: %d", len(all))
+	}
+}
+
+func TestChangeRetention(test *testing.T) {
+	var options = DefaultLevelDBOptions()
+	options.ChangeLog = true
+	options.ChangeRetention = 8
+
+	var db = openStoreTest(NewGoLevelDBStoreWithOptions(test.TempDir(), options))
+	defer db.Close()
+
+	for i := 0; i < 40; i++ {
+		db.SetOne("test", "one", &TestObj{ "One" })
+	}
+
+	if _, err := db.ChangesSince(0, -1); err != ErrChangesPruned {
+		test.Errorf("Expected ErrChangesPruned, got %v", err)
+	}
+
+	var last = db.OldestSnapshotID()
+	var changes, err = db.ChangesSince(last - 8, -1)
+	if err != nil {
+		test.Fatalf("Retained changes unavailable: %v", err)
+	}
+	if len(changes) != 8 {
+		test.Errorf("Wrong retained count: %d", len(changes))
+	}
+}
+
+func TestNoChangeLog(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	if _, err := db.ChangesSince(0, -1); err != ErrNoChangeLog {
+		test.Errorf("Expected ErrNoChangeLog, got %v", err)
+	}
+}
+
+func TestChangeEncoding(test *testing.T) {
+	var event = ChangeEvent{
+		SnapshotID: 12,
+		TypeName: "test",
+		Key: "one",
+		LinkName: "other",
+		OldBlob: []byte{},
+		NewBlob: nil,
+	}
+	var decoded, err = decodeChange(encodeChangeKey(12, 3), encodeChange(event))
+	if err != nil {
+		test.Fatalf("Decode error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, event) {
+		test.Errorf("Round trip changed event: %v", decoded)
+	}
+
+	if _, err = decodeChange(encodeChangeKey(12, 3), []byte{ 4, 't' }); err != errChangeCorrupt {
+		test.Errorf("Truncated record decoded: %v", err)
+	}
+}
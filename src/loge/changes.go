@@ -0,0 +1,256 @@
+package loge
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type BackpressurePolicy int
+
+const (
+	// Events which don't fit are dropped, and counted
+	BACKPRESSURE_DROP BackpressurePolicy = iota
+	// Commits wait for room in the subscription's buffer
+	BACKPRESSURE_BLOCK
+	// The subscription is closed once it falls behind
+	BACKPRESSURE_CLOSE
+)
+
+const db_DEFAULT_SUBSCRIPTION_BUFFER = 256
+
+// One changed object or link set. Blobs are nil where nothing exists.
+type ChangeEvent struct {
+	SnapshotID uint64
+	TypeName string
+	Key LogeKey
+	LinkName string
+	OldBlob []byte
+	NewBlob []byte
+
+	typ *LogeType
+	db *LogeDB
+}
+
+// Empty fields match everything
+type ChangeFilter struct {
+	TypeName string
+	Key LogeKey
+}
+
+type SubscriptionOptions struct {
+	// Defaults to 256
+	BufferSize int
+	// Defaults to BACKPRESSURE_DROP. Events are published once commits
+	// have released their locks, but a full BACKPRESSURE_BLOCK
+	// subscription holds up every commit's return until it catches up.
+	Backpressure BackpressurePolicy
+}
+
+// Events arrive in snapshot order, once each commit has finished.
+// Events is closed by Close, or by BACKPRESSURE_CLOSE.
+type Subscription struct {
+	Events <-chan ChangeEvent
+
+	feed *changeFeed
+	filter ChangeFilter
+	policy BackpressurePolicy
+	events chan ChangeEvent
+	done chan struct{}
+	closeOnce sync.Once
+	dropped uint64
+}
+
+// Orders commits' events by snapshot ID. Every commit publishes, even
+// empty or failed ones, so a gap is always a commit still running.
+type changeFeed struct {
+	lock sync.Mutex
+	subscriptions []*Subscription
+	watchers int32
+	next uint64
+	pending map[uint64][]ChangeEvent
+}
+
+func newChangeFeed(lastSnapshotID uint64) *changeFeed {
+	return &changeFeed{
+		next: lastSnapshotID + 1,
+		pending: make(map[uint64][]ChangeEvent),
+	}
+}
+
+func (db *LogeDB) Subscribe(filter ChangeFilter) *Subscription {
+	return db.SubscribeWithOptions(filter, SubscriptionOptions{})
+}
+
+func (db *LogeDB) SubscribeWithOptions(filter ChangeFilter, options SubscriptionOptions) *Subscription {
+	if options.BufferSize <= 0 {
+		options.BufferSize = db_DEFAULT_SUBSCRIPTION_BUFFER
+	}
+
+	var events = make(chan ChangeEvent, options.BufferSize)
+	var sub = &Subscription{
+		Events: events,
+		feed: db.changes,
+		filter: filter,
+		policy: options.Backpressure,
+		events: events,
+		done: make(chan struct{}),
+	}
+
+	var feed = db.changes
+	feed.lock.Lock()
+	feed.subscriptions = append(feed.subscriptions, sub)
+	atomic.StoreInt32(&feed.watchers, int32(len(feed.subscriptions)))
+	feed.lock.Unlock()
+
+	return sub
+}
+
+// Runs after the transaction commits successfully, in the committing
+// goroutine
+func (t *Transaction) OnCommit(callback func()) {
+	t.onCommit = append(t.onCommit, callback)
+}
+
+func (sub *Subscription) Close() {
+	sub.closeOnce.Do(func() {
+		close(sub.done)
+		sub.feed.lock.Lock()
+		sub.feed.remove(sub)
+		sub.feed.lock.Unlock()
+		close(sub.events)
+	})
+}
+
+// Events lost to BACKPRESSURE_DROP
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+func (event ChangeEvent) Old() interface{} {
+	return event.decode(event.OldBlob, false)
+}
+
+func (event ChangeEvent) New() interface{} {
+	return event.decode(event.NewBlob, false)
+}
+
+// -----------------------------------------------
+// Internals
+// -----------------------------------------------
+
+func (feed *changeFeed) watched() bool {
+	return atomic.LoadInt32(&feed.watchers) > 0
+}
+
+func (feed *changeFeed) publish(sID uint64, events []ChangeEvent) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	feed.pending[sID] = events
+
+	for {
+		var ready, ok = feed.pending[feed.next]
+		if !ok {
+			return
+		}
+		delete(feed.pending, feed.next)
+		feed.next++
+
+		for _, event := range ready {
+			for _, sub := range feed.subscriptions {
+				if sub.filter.matches(event) {
+					sub.deliver(event)
+				}
+			}
+		}
+	}
+}
+
+// Called with the feed lock held
+func (sub *Subscription) deliver(event ChangeEvent) {
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case BACKPRESSURE_DROP:
+		select {
+		case sub.events <- event:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	case BACKPRESSURE_CLOSE:
+		select {
+		case sub.events <- event:
+		default:
+			sub.closeOnce.Do(func() {
+				close(sub.done)
+				sub.feed.remove(sub)
+				close(sub.events)
+			})
+		}
+	default:
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		}
+	}
+}
+
+// Called with the feed lock held. Copies, so publish can carry on
+// through the old list.
+func (feed *changeFeed) remove(sub *Subscription) {
+	for i, other := range feed.subscriptions {
+		if other == sub {
+			feed.subscriptions = append(feed.subscriptions[:i:i], feed.subscriptions[i+1:]...)
+			break
+		}
+	}
+	atomic.StoreInt32(&feed.watchers, int32(len(feed.subscriptions)))
+}
+
+func (filter ChangeFilter) matches(event ChangeEvent) bool {
+	return (filter.TypeName == "" || filter.TypeName == event.TypeName) &&
+		(filter.Key == "" || filter.Key == event.Key)
+}
+
+// Called before the version is applied, while the blob the transaction
+// saw is still the latest
+func (t *Transaction) changeEvent(lv *liveVersion, sID uint64) ChangeEvent {
+	var obj = lv.version.LogeObj
+	var old = lv.blob
+	if !lv.loaded {
+		old = t.context.Get(obj.makeObjRef())
+	}
+	if len(old) == 0 {
+		old = nil
+	}
+
+	return ChangeEvent{
+		SnapshotID: sID,
+		TypeName: obj.Type.Name,
+		Key: obj.Key,
+		LinkName: obj.LinkName,
+		OldBlob: old,
+		typ: obj.Type,
+		db: t.db,
+	}
+}
+
+// Objects which don't exist, or whose type isn't registered, come back
+// as plain nil
+func (event ChangeEvent) decode(blob []byte, toJSON bool) interface{} {
+	if event.LinkName == "" {
+		if len(blob) == 0 || event.typ == nil {
+			return nil
+		}
+		var object, _ = event.typ.Decode(blob, toJSON)
+		return object
+	}
+
+	var obj = &logeObject{ DB: event.db, Type: event.typ, LinkName: event.LinkName }
+	var object, _ = obj.decode(blob, false)
+	return object.(*linkSet).ReadKeys()
+}
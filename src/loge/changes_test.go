@@ -0,0 +1,195 @@
+package loge
+
+import (
+	"testing"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+func TestSubscribe(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Links = LinkSpec{ "other": "test" }
+	db.CreateType(def)
+	db.CreateType(NewTypeDef("counter", 1, &TestCounter{}))
+
+	var all = db.Subscribe(ChangeFilter{})
+	var one = db.Subscribe(ChangeFilter{ TypeName: "test", Key: "one" })
+	var counters = db.Subscribe(ChangeFilter{ TypeName: "counter" })
+	defer all.Close()
+	defer one.Close()
+	defer counters.Close()
+
+	db.SetOne("test", "one", &TestObj{ "One" })
+	db.Transact(func (t *Transaction) {
+		t.Write("test", "one").(*TestObj).Name = "Changed"
+		t.AddLink("test", "other", "one", "two")
+		t.Read("test", "two")
+	}, 0)
+	db.SetOne("counter", "c", &TestCounter{ 1 })
+
+	var first = <-all.Events
+	if first.TypeName != "test" || first.Key != "one" || first.Old() != nil ||
+		first.New().(*TestObj).Name != "One" {
+		test.Errorf("Wrong create event: %v", first)
+	}
+
+	var second = <-all.Events
+	var third = <-all.Events
+	if second.SnapshotID != third.SnapshotID || second.SnapshotID <= first.SnapshotID {
+		test.Errorf("Wrong snapshot IDs: %d, %d, %d",
+			first.SnapshotID, second.SnapshotID, third.SnapshotID)
+	}
+	var update, link = second, third
+	if update.LinkName != "" {
+		update, link = third, second
+	}
+	if update.Old().(*TestObj).Name != "One" || update.New().(*TestObj).Name != "Changed" {
+		test.Errorf("Wrong update event: %v", update)
+	}
+	if link.LinkName != "other" || len(link.Old().([]string)) != 0 ||
+		!reflect.DeepEqual(link.New(), []string{ "two" }) {
+		test.Errorf("Wrong link event: %v", link)
+	}
+
+	if counter := <-all.Events; counter.TypeName != "counter" {
+		test.Errorf("Wrong counter event: %v", counter)
+	}
+	if len(all.Events) != 0 {
+		test.Errorf("Unexpected events: %d", len(all.Events))
+	}
+
+	if len(one.Events) != 3 {
+		test.Errorf("Key filter passed %d events", len(one.Events))
+	}
+	if len(counters.Events) != 1 {
+		test.Errorf("Type filter passed %d events", len(counters.Events))
+	}
+
+	all.Close()
+	db.SetOne("test", "one", &TestObj{ "Again" })
+	if _, ok := <-all.Events; ok {
+		test.Error("Closed subscription got an event")
+	}
+	if len(one.Events) != 4 {
+		test.Error("Closing one subscription stopped another")
+	}
+}
+
+func TestSubscriptionBackpressure(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	var dropping = db.SubscribeWithOptions(ChangeFilter{},
+		SubscriptionOptions{ BufferSize: 2, Backpressure: BACKPRESSURE_DROP })
+	var closing = db.SubscribeWithOptions(ChangeFilter{},
+		SubscriptionOptions{ BufferSize: 2, Backpressure: BACKPRESSURE_CLOSE })
+	defer dropping.Close()
+
+	for _, key := range []LogeKey{ "one", "two", "three", "four" } {
+		db.SetOne("test", key, &TestObj{ string(key) })
+	}
+
+	if dropping.Dropped() != 2 || len(dropping.Events) != 2 {
+		test.Errorf("Dropped %d, kept %d", dropping.Dropped(), len(dropping.Events))
+	}
+	if event := <-dropping.Events; event.Key != "one" {
+		test.Errorf("Kept the wrong event: %v", event)
+	}
+
+	var received = 0
+	for _ = range closing.Events {
+		received++
+	}
+	if received != 2 {
+		test.Errorf("Got %d events before close", received)
+	}
+	closing.Close()
+}
+
+func TestBlockingSubscription(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	var defaulted = db.SubscribeWithOptions(ChangeFilter{}, SubscriptionOptions{ BufferSize: 1 })
+	var blocking = db.SubscribeWithOptions(ChangeFilter{},
+		SubscriptionOptions{ BufferSize: 1, Backpressure: BACKPRESSURE_BLOCK })
+	defer defaulted.Close()
+	defer blocking.Close()
+
+	db.SetOne("test", "one", &TestObj{ "One" })
+	var sID = atomic.LoadUint64(&db.lastSnapshotID)
+
+	var done = make(chan struct{})
+	go func() {
+		db.SetOne("test", "one", &TestObj{ "Two" })
+		close(done)
+	}()
+
+	// The blocked commit has finished with its locks
+	var obj *logeObject
+	var deadline = time.Now().Add(time.Second)
+	for obj == nil || !obj.Lock.TryLock() {
+		if time.Now().After(deadline) {
+			test.Fatal("Blocked commit held its locks")
+		}
+		time.Sleep(time.Millisecond)
+		if atomic.LoadUint64(&db.lastSnapshotID) > sID {
+			obj, _ = db.cache.get(db.makeObjRef("test", "one").CacheKey)
+		}
+	}
+	obj.Lock.Unlock()
+
+	select {
+	case <-done:
+		test.Error("Commit didn't wait for a blocking subscriber")
+	default:
+	}
+
+	<-blocking.Events
+	<-done
+	if defaulted.Dropped() != 1 {
+		test.Errorf("Default subscription dropped %d", defaulted.Dropped())
+	}
+	if event := <-blocking.Events; event.New().(*TestObj).Name != "Two" {
+		test.Errorf("Wrong event after blocking: %v", event)
+	}
+}
+
+func TestOnCommit(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	db.SetOne("test", "one", &TestObj{ "One" })
+
+	var calls = 0
+
+	db.Transact(func (t *Transaction) {
+		t.OnCommit(func() {
+			calls++
+			if db.ReadOne("test", "one").(*TestObj).Name != "Changed" {
+				test.Error("Callback ran before commit")
+			}
+		})
+		t.Write("test", "one").(*TestObj).Name = "Changed"
+	}, 0)
+
+	var t1 = db.CreateTransaction()
+	var t2 = db.CreateTransaction()
+	t1.OnCommit(func() { calls++ })
+	t1.Write("test", "one").(*TestObj).Name = "First"
+	t2.Write("test", "one").(*TestObj).Name = "Second"
+	t2.Commit()
+	if t1.Commit() {
+		test.Fatal("Conflicting commit succeeded")
+	}
+
+	var t3 = db.CreateTransaction()
+	t3.OnCommit(func() { calls++ })
+	t3.Cancel()
+	t3.Commit()
+
+	if calls != 1 {
+		test.Errorf("Callbacks ran %d times", calls)
+	}
+}
@@ -0,0 +1,121 @@
+package loge
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+// Adds delta to a numeric field, creating the object if needed. Unless
+// something else in the transaction reads or writes the object,
+// concurrent increments are merged at commit instead of aborting.
+// Validated types never merge, so validators see what commits.
+func (t *Transaction) Increment(typeName string, key LogeKey, field string, delta int64) {
+	var ref = t.db.makeObjRef(typeName, key)
+	var lv = t.getVersion(ref, true, true, ref.Type.Validator == nil)
+	var obj = lv.version.LogeObj
+
+	if lv.object == nil || !obj.hasValue(lv.object) {
+		lv.object = obj.Type.newValue(t.giveJSON)
+	}
+	incrementField(lv.object, field, delta)
+
+	if lv.increments == nil {
+		lv.increments = make(map[string]int64)
+	}
+	lv.increments[field] += delta
+}
+
+func (t *Transaction) linkDelta(ref ObjRef, target LogeKey, add bool) {
+	var lv = t.getVersion(ref, true, true, ref.Type.Validator == nil)
+	var links = lv.object.(*linkSet)
+
+	if add {
+		links.Add(string(target))
+	} else {
+		links.Remove(string(target))
+	}
+
+	if lv.linkDeltas == nil {
+		lv.linkDeltas = make(map[string]bool)
+	}
+	lv.linkDeltas[string(target)] = add
+}
+
+// Replays the transaction's changes over the latest version. Called
+// with the object locked for commit, so that can't move.
+func (t *Transaction) rebase(lv *liveVersion) {
+	var obj = lv.version.LogeObj
+	var blob, ok = obj.latestBlob()
+
+	if !ok {
+		var ref = obj.makeObjRef()
+		var context = t.db.store.NewContext(atomic.LoadUint64(&t.db.lastSnapshotID))
+		blob = context.Get(ref)
+		context.Rollback()
+	}
+
+	var object, _ = obj.decode(blob, t.giveJSON)
+
+	if obj.LinkName != "" {
+		var links = object.(*linkSet)
+		for target, add := range lv.linkDeltas {
+			if add {
+				links.Add(target)
+			} else {
+				links.Remove(target)
+			}
+		}
+	} else if len(lv.increments) > 0 {
+		if object == nil || !obj.hasValue(object) {
+			object = obj.Type.newValue(t.giveJSON)
+		}
+		for field, delta := range lv.increments {
+			incrementField(object, field, delta)
+		}
+	}
+
+	lv.object = object
+	lv.blob = blob
+	lv.loaded = true
+}
+
+// The current blob, if the version chain is sure it's the latest
+func (obj *logeObject) latestBlob() ([]byte, bool) {
+	obj.shard.lock.Lock()
+	defer obj.shard.lock.Unlock()
+
+	var current = obj.Current
+	if current == nil || !current.loaded || current.snapshotID < obj.cachedAt {
+		return nil, false
+	}
+	return current.Blob, true
+}
+
+func (t *LogeType) newValue(toJSON bool) interface{} {
+	if toJSON {
+		return make(map[string]interface{})
+	}
+	return reflect.New(reflect.TypeOf(t.Exemplar).Elem()).Interface()
+}
+
+func incrementField(object interface{}, field string, delta int64) {
+	if fields, ok := object.(map[string]interface{}); ok {
+		var current, _ = fields[field].(float64)
+		fields[field] = current + float64(delta)
+		return
+	}
+
+	var value = reflect.Indirect(reflect.ValueOf(object)).FieldByName(field)
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(value.Int() + delta)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(value.Uint() + uint64(delta))
+	case reflect.Float32, reflect.Float64:
+		value.SetFloat(value.Float() + float64(delta))
+	default:
+		panic(fmt.Sprintf("Can't increment field %s of %T", field, object))
+	}
+}
@@ -0,0 +1,80 @@
+package loge
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestIncrementMerges(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("counters", 1, &TestCounter{}))
+
+	var first = db.CreateTransaction()
+	var second = db.CreateTransaction()
+
+	first.Increment("counters", "hits", "Value", 2)
+	second.Increment("counters", "hits", "Value", 3)
+
+	if !second.Commit() || !first.Commit() {
+		test.Error("Concurrent increments aborted")
+	}
+
+	if value := db.ReadOne("counters", "hits").(*TestCounter).Value; value != 5 {
+		test.Errorf("Increments not merged: %d", value)
+	}
+}
+
+func TestIncrementAfterReadConflicts(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("counters", 1, &TestCounter{}))
+	db.SetOne("counters", "hits", &TestCounter{ 1 })
+
+	var reader = db.CreateTransaction()
+	if reader.Read("counters", "hits").(*TestCounter).Value == 1 {
+		reader.Increment("counters", "hits", "Value", 1)
+	}
+
+	db.Transact(func (t *Transaction) {
+		t.Increment("counters", "hits", "Value", 10)
+	}, 0)
+
+	if reader.Commit() {
+		test.Error("Increment based on a stale read committed")
+	}
+	if value := db.ReadOne("counters", "hits").(*TestCounter).Value; value != 11 {
+		test.Errorf("Wrong value: %d", value)
+	}
+}
+
+func TestLinkDeltasMerge(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Links = LinkSpec{ "other": "test" }
+	db.CreateType(def)
+
+	db.Transact(func (t *Transaction) {
+		t.AddLink("test", "other", "one", "gone")
+	}, 0)
+
+	var adder = db.CreateTransaction()
+	var remover = db.CreateTransaction()
+
+	adder.AddLink("test", "other", "one", "two")
+	adder.AddLink("test", "other", "one", "three")
+	adder.RemoveLink("test", "other", "one", "three")
+	remover.RemoveLink("test", "other", "one", "gone")
+
+	if !adder.Commit() || !remover.Commit() {
+		test.Error("Concurrent link changes aborted")
+	}
+
+	if links := db.ReadLinksOne("test", "other", "one"); !reflect.DeepEqual(links, []string{ "two" }) {
+		test.Errorf("Link deltas not merged: %v", links)
+	}
+	if keys := db.Find("test", "other", "gone"); len(keys) != 0 {
+		test.Errorf("Index not updated for merged removal: %v", keys)
+	}
+	if keys := db.Find("test", "other", "two"); !reflect.DeepEqual(keys, []LogeKey{ "one" }) {
+		test.Errorf("Index not updated for merged add: %v", keys)
+	}
+}
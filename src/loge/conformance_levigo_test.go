@@ -0,0 +1,15 @@
+//go:build cgo
+// +build cgo
+
+package loge_test
+
+import (
+	"testing"
+
+	"loge"
+	"loge/storetest"
+)
+
+func TestLevelDBConformance(test *testing.T) {
+	storetest.Run(test, loge.NewLevelDBStore, storetest.Options{})
+}
@@ -0,0 +1,30 @@
+package loge_test
+
+import (
+	"testing"
+
+	"loge"
+	"loge/storetest"
+)
+
+func TestMemStoreConformance(test *testing.T) {
+	storetest.Run(test, func(dir string) loge.LogeStore {
+		return loge.NewMemStore()
+	}, storetest.Options{ Volatile: true })
+}
+
+func TestGoLevelDBConformance(test *testing.T) {
+	storetest.Run(test, loge.NewGoLevelDBStore, storetest.Options{})
+}
+
+func TestGoLevelDBChangeLogConformance(test *testing.T) {
+	storetest.Run(test, func(dir string) loge.LogeStore {
+		var options = loge.DefaultLevelDBOptions()
+		options.ChangeLog = true
+		return loge.NewGoLevelDBStoreWithOptions(dir, options)
+	}, storetest.Options{})
+}
+
+func TestPersistentMemStoreConformance(test *testing.T) {
+	storetest.Run(test, loge.NewPersistentMemStore, storetest.Options{})
+}
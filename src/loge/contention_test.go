@@ -5,6 +5,8 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"os"
+	"time"
 )
 
 type TestCounter struct {
@@ -13,12 +15,78 @@ type TestCounter struct {
 
 
 func BenchmarkNoContention(b *testing.B) {
+	benchmarkNoContention(b, NewLogeDB(NewMemStore()))
+}
+
+func BenchmarkContention(b *testing.B) {
+	benchmarkContention(b, NewLogeDB(NewMemStore()))
+}
+
+func BenchmarkCommutativeContention(b *testing.B) {
+	benchmarkCommutativeContention(b, NewLogeDB(NewMemStore()))
+}
+
+// Each transaction increments two neighbouring counters, so commits
+// overlap in both directions round the ring
+func BenchmarkOverlappingContention(b *testing.B) {
+	benchmarkOverlappingContention(b, NewLogeDB(NewMemStore()))
+}
+
+func BenchmarkDisjointReads(b *testing.B) {
+	benchmarkDisjointReads(b, NewLogeDB(NewMemStore()), CACHE_TRANSIENT)
+}
+
+func BenchmarkDisjointReadsRetained(b *testing.B) {
+	benchmarkDisjointReads(b, NewLogeDB(NewMemStore()), CACHE_LRU)
+}
+
+func BenchmarkLevelDBNoContentionUnbatched(b *testing.B) {
+	benchmarkNoContention(b, newBenchLevelDB(b, 1, 0))
+}
+
+func BenchmarkLevelDBNoContentionBatched(b *testing.B) {
+	benchmarkNoContention(b, newBenchLevelDB(b, ldb_DEFAULT_BATCH_SIZE, 0))
+}
+
+func BenchmarkLevelDBNoContentionBatchedLatency(b *testing.B) {
+	benchmarkNoContention(b, newBenchLevelDB(b, ldb_DEFAULT_BATCH_SIZE, 100 * time.Microsecond))
+}
+
+func BenchmarkLevelDBContentionUnbatched(b *testing.B) {
+	benchmarkContention(b, newBenchLevelDB(b, 1, 0))
+}
+
+func BenchmarkLevelDBContentionBatched(b *testing.B) {
+	benchmarkContention(b, newBenchLevelDB(b, ldb_DEFAULT_BATCH_SIZE, 0))
+}
+
+func BenchmarkLevelDBOverlappingContention(b *testing.B) {
+	benchmarkOverlappingContention(b, newBenchLevelDB(b, ldb_DEFAULT_BATCH_SIZE, 0))
+}
+
+
+func newBenchLevelDB(b *testing.B, batchSize int, latency time.Duration) *LogeDB {
+	var dir, err = os.MkdirTemp("", "loge-bench")
+	if err != nil {
+		b.Fatalf("Can't create temp dir: %v", err)
+	}
+
+	var opts = DefaultLevelDBOptions()
+	opts.MaxBatchSize = batchSize
+	opts.MaxBatchLatency = latency
+	var db = NewLogeDB(NewGoLevelDBStoreWithOptions(dir, opts))
+
+	b.Cleanup(func() { os.RemoveAll(dir) })
+	b.Cleanup(db.Close)
+	return db
+}
+
+func benchmarkNoContention(b *testing.B, db *LogeDB) {
 	b.StopTimer()
 
 	var procs = runtime.NumCPU()
 	var origProcs = runtime.GOMAXPROCS(procs)
 
-	var db = NewLogeDB(NewMemStore())
 	db.CreateType(NewTypeDef("counters", 1, &TestCounter{}))
 
 	db.Transact(func (t *Transaction) {
@@ -56,13 +124,12 @@ func BenchmarkNoContention(b *testing.B) {
 
 
 
-func BenchmarkContention(b *testing.B) {
+func benchmarkContention(b *testing.B, db *LogeDB) {
 	b.StopTimer()
 
 	var procs = runtime.NumCPU()
 	var origProcs = runtime.GOMAXPROCS(procs)
 
-	var db = NewLogeDB(NewMemStore())
 	db.CreateType(NewTypeDef("counters", 1, &TestCounter{}))
 
 	db.Transact(func (t *Transaction) {
@@ -89,9 +156,165 @@ func BenchmarkContention(b *testing.B) {
 		}
 	}, 0)
 
+	reportContention(b, db)
+	runtime.GOMAXPROCS(origProcs)
+}
+
+
+// As benchmarkContention, but merging increments rather than aborting
+func benchmarkCommutativeContention(b *testing.B, db *LogeDB) {
+	b.StopTimer()
+
+	var procs = runtime.NumCPU()
+	var origProcs = runtime.GOMAXPROCS(procs)
+
+	db.CreateType(NewTypeDef("counters", 1, &TestCounter{}))
+
+	b.StartTimer()
+
+	var group sync.WaitGroup
+	for i := 0; i < procs; i++ {
+		group.Add(1)
+		go func() {
+			var actor = func(t *Transaction) {
+				t.Increment("counters", "contended", "Value", 1)
+			}
+			for n := 0; n < b.N; n++ {
+				db.Transact(actor, 0)
+			}
+			group.Done()
+		}()
+	}
+	group.Wait()
+
+	b.StopTimer()
+
+	var counter = db.ReadOne("counters", "contended").(*TestCounter)
+	if counter.Value != uint32(b.N * procs) {
+		b.Errorf("Wrong count for counter: %d / %d", counter.Value, b.N * procs)
+	}
+
+	reportContention(b, db)
+	runtime.GOMAXPROCS(origProcs)
+}
+
+func benchmarkOverlappingContention(b *testing.B, db *LogeDB) {
+	b.StopTimer()
+
+	var procs = runtime.NumCPU()
+	var origProcs = runtime.GOMAXPROCS(procs)
+
+	db.CreateType(NewTypeDef("counters", 1, &TestCounter{}))
+
+	db.Transact(func (t *Transaction) {
+		for i := 0; i < procs; i++ {
+			t.Set("counters", LogeKey(strconv.Itoa(i)), &TestCounter{Value: 0})
+		}
+	}, 0)
+
+	b.StartTimer()
+
+	var group sync.WaitGroup
+	for i := 0; i < procs; i++ {
+		// Odd goroutines take the pair in the opposite order
+		var first = LogeKey(strconv.Itoa(i))
+		var second = LogeKey(strconv.Itoa((i + 1) % procs))
+		if i % 2 == 1 {
+			first, second = second, first
+		}
+		group.Add(1)
+		go func() {
+			var actor = func(t *Transaction) {
+				Increment(t, first)
+				Increment(t, second)
+			}
+			for n := 0; n < b.N; n++ {
+				db.Transact(actor, 0)
+			}
+			group.Done()
+		}()
+	}
+	group.Wait()
+
+	b.StopTimer()
+
+	db.Transact(func (t *Transaction) {
+		for i := 0; i < procs; i++ {
+			var counter = t.Read("counters", LogeKey(strconv.Itoa(i))).(*TestCounter)
+			if counter.Value != uint32(2 * b.N) {
+				b.Errorf("Wrong count for counter %d: %d / %d",
+					i, counter.Value, 2 * b.N)
+			}
+		}
+	}, 0)
+
+	reportContention(b, db)
 	runtime.GOMAXPROCS(origProcs)
 }
 
+// Many more goroutines than cores, each reading its own keys in
+// transactions, so only the cache itself is shared
+func benchmarkDisjointReads(b *testing.B, db *LogeDB, policy CachePolicy) {
+	b.StopTimer()
+
+	const readers = 64
+	const keysPerReader = 8
+
+	var def = NewTypeDef("counters", 1, &TestCounter{})
+	def.CachePolicy = policy
+	db.CreateType(def)
+	db.SetCacheSize(readers * keysPerReader)
+
+	var keys = make([][]LogeKey, readers)
+	db.Transact(func (t *Transaction) {
+		for i := range keys {
+			for j := 0; j < keysPerReader; j++ {
+				var key = LogeKey(strconv.Itoa(i * keysPerReader + j))
+				keys[i] = append(keys[i], key)
+				t.Set("counters", key, &TestCounter{Value: uint32(i)})
+			}
+		}
+	}, 0)
+
+	b.StartTimer()
+
+	var group sync.WaitGroup
+	for i := range keys {
+		var mine = keys[i]
+		var expected = uint32(i)
+		group.Add(1)
+		go func() {
+			var actor = func(t *Transaction) {
+				for _, key := range mine {
+					if t.Read("counters", key).(*TestCounter).Value != expected {
+						b.Errorf("Wrong value for %s", key)
+					}
+				}
+			}
+			for n := 0; n < b.N; n++ {
+				db.Transact(actor, 0)
+			}
+			group.Done()
+		}()
+	}
+	group.Wait()
+}
+
+// Aborts and lock waits per transaction, alongside the timings
+func reportContention(b *testing.B, db *LogeDB) {
+	var metrics, ok = db.Metrics().(*MemMetrics)
+	if !ok {
+		return
+	}
+	var snapshot = metrics.Snapshot()
+	var commits = float64(snapshot.Counters[metric_COMMITS])
+	if commits == 0 {
+		return
+	}
+	b.ReportMetric(float64(snapshot.Counters[metric_ABORTS]) / commits, "aborts/commit")
+	b.ReportMetric(float64(snapshot.Histograms[metric_LOCK_WAIT_SECONDS].Count) / commits, "waits/commit")
+}
+
 
 func LoopIncrement(db *LogeDB, key LogeKey, group *sync.WaitGroup, count int) {
 	var actor = func(t *Transaction) { Increment(t, key) }
@@ -0,0 +1,89 @@
+package loge
+
+import (
+	"reflect"
+)
+
+// Copies everything reachable through exported fields, so nothing handed
+// out can reach back into a transaction's objects. Objects are trees, so
+// cycles aren't handled.
+func deepCopy(object interface{}) interface{} {
+	if object == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(object)).Interface()
+}
+
+func copyValue(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		var copied = reflect.New(value.Type().Elem())
+		copied.Elem().Set(copyValue(value.Elem()))
+		return copied
+
+	case reflect.Struct:
+		var copied = reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if copied.Field(i).CanSet() {
+				copied.Field(i).Set(copyValue(value.Field(i)))
+			}
+		}
+		return copied
+
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		var copied = reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		copyElements(copied, value)
+		return copied
+
+	case reflect.Array:
+		var copied = reflect.New(value.Type()).Elem()
+		copyElements(copied, value)
+		return copied
+
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		var copied = reflect.MakeMapWithSize(value.Type(), value.Len())
+		var iter = value.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+		}
+		return copied
+
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		var copied = reflect.New(value.Type()).Elem()
+		copied.Set(copyValue(value.Elem()))
+		return copied
+	}
+
+	return value
+}
+
+func copyElements(dst reflect.Value, src reflect.Value) {
+	if isFlat(src.Type().Elem()) {
+		reflect.Copy(dst, src)
+		return
+	}
+	for i := 0; i < src.Len(); i++ {
+		dst.Index(i).Set(copyValue(src.Index(i)))
+	}
+}
+
+func isFlat(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Struct, reflect.Slice, reflect.Array, reflect.Map, reflect.Interface:
+		return false
+	}
+	return true
+}
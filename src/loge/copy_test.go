@@ -0,0 +1,103 @@
+package loge
+
+import (
+	"testing"
+	"reflect"
+)
+
+type TestDeepObj struct {
+	Name string
+	Tags []string
+	Inner TestDeepInner
+}
+
+type TestDeepInner struct {
+	Counts []uint32
+}
+
+func TestReadIsolation(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("nested", 1, &TestDeepObj{}))
+
+	db.SetOne("nested", "one", &TestDeepObj{
+		Name: "One",
+		Tags: []string{ "a", "b" },
+		Inner: TestDeepInner{ Counts: []uint32{ 1, 2 } },
+	})
+
+	db.Transact(func (t *Transaction) {
+		var read = t.Read("nested", "one").(*TestDeepObj)
+		read.Name = "Changed"
+		read.Tags[0] = "changed"
+		read.Inner.Counts[0] = 100
+
+		var written = t.Write("nested", "one").(*TestDeepObj)
+		if written == read {
+			test.Error("Write returned the object from Read")
+		}
+		if written.Name != "One" || written.Tags[0] != "a" || written.Inner.Counts[0] != 1 {
+			test.Errorf("Changes to read object leaked into write: %v", written)
+		}
+
+		written.Tags = append(written.Tags, "c")
+		if t.Write("nested", "one").(*TestDeepObj) != written {
+			test.Error("Second Write returned a different object")
+		}
+
+		var reread = t.Read("nested", "one").(*TestDeepObj)
+		if !reflect.DeepEqual(reread.Tags, []string{ "a", "b", "c" }) {
+			test.Errorf("Read missed earlier write: %v", reread.Tags)
+		}
+		reread.Inner.Counts[1] = 200
+	}, 0)
+
+	var stored = db.ReadOne("nested", "one").(*TestDeepObj)
+	if stored.Name != "One" || !reflect.DeepEqual(stored.Inner.Counts, []uint32{ 1, 2 }) {
+		test.Errorf("Changes to read objects committed: %v", stored)
+	}
+	if !reflect.DeepEqual(stored.Tags, []string{ "a", "b", "c" }) {
+		test.Errorf("Write lost: %v", stored.Tags)
+	}
+}
+
+func TestDeepCopy(test *testing.T) {
+	type inner struct {
+		Values map[string][]int
+		Any interface{}
+	}
+	type outer struct {
+		Inner *inner
+		Pairs [2][]int
+		private []int
+	}
+
+	var shared = []int{ 1 }
+	var original = &outer{
+		Inner: &inner{
+			Values: map[string][]int{ "x": { 1, 2 } },
+			Any: []string{ "y" },
+		},
+		Pairs: [2][]int{ { 3 }, { 4 } },
+		private: shared,
+	}
+
+	var copied = deepCopy(original).(*outer)
+	if !reflect.DeepEqual(copied, original) {
+		test.Fatalf("Copy differs: %v", copied)
+	}
+
+	copied.Inner.Values["x"][0] = 10
+	copied.Inner.Values["z"] = nil
+	copied.Inner.Any.([]string)[0] = "changed"
+	copied.Pairs[1][0] = 40
+
+	if original.Inner.Values["x"][0] != 1 || len(original.Inner.Values) != 1 {
+		test.Error("Map shared with copy")
+	}
+	if original.Inner.Any.([]string)[0] != "y" {
+		test.Error("Interface value shared with copy")
+	}
+	if original.Pairs[1][0] != 4 {
+		test.Error("Array element shared with copy")
+	}
+}
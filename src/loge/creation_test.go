@@ -53,6 +53,8 @@ func TestCreationScoping(test *testing.T) {
 	if trans3.Exists("test", "one") {
 		test.Error("Created object visible when first read after commit")
 	}
+	trans2.Cancel()
+	trans3.Cancel()
 }
 
 
@@ -3,6 +3,7 @@ package loge
 import (
 	"fmt"
 	"time"
+	"sync"
 	"sync/atomic"
 	"reflect"
 
@@ -13,36 +14,113 @@ import (
 type LogeDB struct {
 	types typeMap
 	store LogeStore
-	cache objCache
+	cache *objCache
 	lastSnapshotID uint64
-	lock spinLock
 	linkTypeSpec *spack.TypeSpec
+
+	lru *objectLRU
+	cacheHits uint64
+	cacheMisses uint64
+	cacheEvictions uint64
+
+	snapshots snapshotSet
+	// When each open snapshot was first taken
+	pinnedAt map[uint64]time.Time
+	committing snapshotSet
+	snapshotLock sync.Mutex
+	commitCount uint64
+
+	changes *changeFeed
+	changeLog ChangeLogStore
+
+	expiring bool
+	reaperStop chan struct{}
+	reaperDone chan struct{}
+
+	serializable bool
+	scanLock sync.Mutex
+	recentCommits []commitRecord
+
+	metrics Metrics
+	tracer Tracer
+	lastTransactionID uint64
+
+	logger Logger
+}
+
+type DBOptions struct {
+	// Defaults to warnings and errors on stderr
+	Logger Logger
+	// Defaults to a MemMetrics
+	Metrics Metrics
+	Tracer Tracer
+	// Maximum objects retained by CACHE_LRU types
+	CacheSize int
+	// Also abort transactions whose Find, FindSlice or ListSlice
+	// results were changed by a concurrent commit
+	Serializable bool
 }
 
 func NewLogeDB(store LogeStore) *LogeDB {
+	return NewLogeDBWithOptions(store, DBOptions{})
+}
+
+func NewLogeDBWithOptions(store LogeStore, options DBOptions) *LogeDB {
+	if options.Logger == nil {
+		options.Logger = defaultLogger()
+	} else {
+		store.SetLogger(options.Logger)
+	}
+	if options.Metrics == nil {
+		options.Metrics = NewMemMetrics()
+	}
+	if options.CacheSize == 0 {
+		options.CacheSize = db_DEFAULT_CACHE_SIZE
+	}
+
+	var lastSnapshotID uint64 = 1
+	if snapshots, ok := store.(SnapshotStore); ok {
+		if last := snapshots.LastSnapshotID(); last > lastSnapshotID {
+			lastSnapshotID = last
+		}
+	}
+	var changeLog ChangeLogStore
+	if log, ok := store.(ChangeLogStore); ok && log.KeepsChanges() {
+		changeLog = log
+	}
+
 	return &LogeDB {
 		types: make(typeMap),
 		store: store,
-		cache: make(objCache),
-		lastSnapshotID: 1,
+		cache: newObjCache(),
+		lastSnapshotID: lastSnapshotID,
 		linkTypeSpec: spack.MakeTypeSpec([]string{}),
+		lru: newObjectLRU(options.CacheSize),
+		snapshots: make(snapshotSet),
+		pinnedAt: make(map[uint64]time.Time),
+		committing: make(snapshotSet),
+		metrics: options.Metrics,
+		tracer: options.Tracer,
+		logger: options.Logger,
+		changes: newChangeFeed(lastSnapshotID),
+		changeLog: changeLog,
+		serializable: options.Serializable,
 	}
 }
 
 
-type typeMap map[string]*logeType
-
-type objCache map[string]*logeObject
+type typeMap map[string]*LogeType
 
 type Transactor func(*Transaction)
 
 
 func (db *LogeDB) Close() {
-	db.store.close()
+	db.stopReaper()
+	db.store.Close()
 }
 
-func (db *LogeDB) CreateType(def *TypeDef) *logeType {
-	var vt = db.store.getSpackType(def.Name)
+func (db *LogeDB) CreateType(def *TypeDef) *LogeType {
+	var vt = db.store.GetSpackType(def.Name)
 
 	var spackExemplar interface{}
 	if def.Exemplar != nil {
@@ -53,35 +131,55 @@ func (db *LogeDB) CreateType(def *TypeDef) *logeType {
 
 	vt.AddVersion(def.Version, spackExemplar, def.Upgrader)
 	var typ = newType(def.Name, def.Version, def.Exemplar, def.Links, vt)
+	typ.CachePolicy = def.CachePolicy
+	typ.Validator = def.Validator
+	typ.TTL = def.TTL
+	typ.Expires = def.Expires
+	typ.KeepVersions = def.KeepVersions
 	db.types[typ.Name] = typ
-	db.store.registerType(typ)
+	db.store.RegisterType(typ)
+
+	// Tag zero is the object itself
+	for name, info := range typ.Links {
+		if info.Tag == 0 {
+			panic(fmt.Sprintf("Store didn't tag link %s.%s\n", typ.Name, name))
+		}
+	}
+
+	if typ.expires() {
+		db.createExpiryType()
+		db.expiring = true
+	}
 	return typ
 }
 
+// The transaction holds its snapshot until Commit or Cancel, and until
+// then older versions of anything it could read are kept, so it must
+// always be finished. OldestSnapshotAge shows any left open.
 func (db *LogeDB) CreateTransaction() *Transaction {
-	var tID = db.lastSnapshotID
+	var tID = db.beginSnapshot()
 	return NewTransaction(db, tID)
 }
 
-func (db *LogeDB) newSnapshotID() uint64 {
-	return atomic.AddUint64(&db.lastSnapshotID, 1)
-}
-
 func (db *LogeDB) Transact(actor Transactor, timeout time.Duration) bool {
-	var t = db.CreateTransaction()
-	return db.doTransact(t, actor, timeout)
+	return db.doTransact(actor, timeout, false)
 }
 
 func (db *LogeDB) TransactJSON(actor Transactor, timeout time.Duration) bool {
-	var t = db.CreateTransaction()
-	t.giveJSON = true
-	return db.doTransact(t, actor, timeout)
+	return db.doTransact(actor, timeout, true)
 }
 
-func (db *LogeDB) doTransact(t *Transaction, actor Transactor, timeout time.Duration) bool {
+func (db *LogeDB) doTransact(actor Transactor, timeout time.Duration, giveJSON bool) bool {
 	var start = time.Now()
-	for {
-		actor(t)
+	for attempt := 1; ; attempt++ {
+		// Each attempt needs a fresh snapshot, or it would just abort again
+		var t = db.CreateTransaction()
+		t.giveJSON = giveJSON
+		t.attempt = attempt
+		if attempt > 1 {
+			t.trace(TRACE_RETRY, nil, "")
+		}
+		t.run(actor)
 		if t.cancelled {
 			return false
 		}
@@ -94,6 +192,7 @@ func (db *LogeDB) doTransact(t *Transaction, actor Transactor, timeout time.Dura
 		if timeout > 0 && time.Since(start) > timeout {
 			break
 		}
+		db.metrics.Count(metric_RETRIES, 1)
 	}
 	return false
 }
@@ -103,23 +202,23 @@ func (db *LogeDB) doTransact(t *Transaction, actor Transactor, timeout time.Dura
 // -----------------------------------------------
 
 func (db *LogeDB) ExistsOne(typeName string, key LogeKey) (exists bool) {
-	db.Transact(func (t *Transaction) {
+	db.View(func (t *ReadTransaction) {
 		exists = t.Exists(typeName, key)
-	}, 0)
+	})
 	return
 }
 
 func (db *LogeDB) ReadOne(typeName string, key LogeKey) (obj interface{}) {
-	db.Transact(func (t *Transaction) {
+	db.View(func (t *ReadTransaction) {
 		obj = t.Read(typeName, key)
-	}, 0)
+	})
 	return
 }
 
 func (db *LogeDB) ReadLinksOne(typeName string, linkName string, key LogeKey) (links []string) {
-	db.Transact(func (t *Transaction) {
+	db.View(func (t *ReadTransaction) {
 		links = t.ReadLinks(typeName, linkName, key)
-	}, 0)
+	})
 	return
 }
 
@@ -136,23 +235,23 @@ func (db *LogeDB) DeleteOne(typeName string, key LogeKey) {
 }
 
 func (db *LogeDB) Find(typeName string, linkName string, target LogeKey) (results []LogeKey) {
-	db.Transact(func (t *Transaction) {
+	db.View(func (t *ReadTransaction) {
 		results = t.Find(typeName, linkName, target).All()
-	}, 0)
+	})
 	return
 }
 
 func (db *LogeDB) FindSlice(typeName string, linkName string, target LogeKey, from LogeKey, limit int) (results []LogeKey) {	
-	db.Transact(func (t *Transaction) {
+	db.View(func (t *ReadTransaction) {
 		results = t.FindSlice(typeName, linkName, target, from, limit).All()
-	}, 0)
+	})
 	return
 }
 
 func (db *LogeDB) ListSlice(typeName string, from LogeKey, limit int) (results []LogeKey) {	
-	db.Transact(func (t *Transaction) {
+	db.View(func (t *ReadTransaction) {
 		results = t.ListSlice(typeName, from, limit).All()
-	}, 0)
+	})
 	return
 }
 
@@ -160,7 +259,7 @@ func (db *LogeDB) ListSlice(typeName string, from LogeKey, limit int) (results [
 // Internals
 // -----------------------------------------------
 
-func (db *LogeDB) makeObjRef(typeName string, key LogeKey) objRef {
+func (db *LogeDB) makeObjRef(typeName string, key LogeKey) ObjRef {
 	typ, ok := db.types[typeName]
 	if !ok {
 		panic(fmt.Sprintf("Type not registered: %s", typeName))
@@ -168,7 +267,7 @@ func (db *LogeDB) makeObjRef(typeName string, key LogeKey) objRef {
 	return makeObjRef(typ, key)
 }
 
-func (db *LogeDB) makeLinkRef(typeName string, linkName string, key LogeKey) objRef {
+func (db *LogeDB) makeLinkRef(typeName string, linkName string, key LogeKey) ObjRef {
 	typ, ok := db.types[typeName]
 	if !ok {
 		panic(fmt.Sprintf("Type not registered: %s", typeName))
@@ -177,52 +276,93 @@ func (db *LogeDB) makeLinkRef(typeName string, linkName string, key LogeKey) obj
 }
 
 
-func (db *LogeDB) acquireVersion(ref objRef, context transactionContext, load bool) *objectVersion {
+// Loading happens outside the shard lock, so it never waits on other
+// objects. Two transactions may both load a version; the first wins.
+func (db *LogeDB) acquireVersion(ref ObjRef, context TransactionContext, load bool) *liveVersion {
 	var typeName = ref.Type.Name
 	var key = ref.Key
 
 	var objKey = ref.String()
 	var typ = db.types[typeName]
 
-	db.lock.SpinLock()
-	var obj, ok = db.cache[objKey]
+	var shard = db.cache.shard(objKey)
+	shard.lock.Lock()
+	var obj, ok = shard.objects[objKey]
 
 	if !ok {
 		obj = initializeObject(db, typ, key)
 		if ref.IsLink() { 
 			obj.LinkName = ref.LinkName
 		}
+		obj.cachedAt = atomic.LoadUint64(&db.lastSnapshotID)
+		obj.shard = shard
+		shard.objects[objKey] = obj
+
+		// Unloaded, but enough for older transactions to see they
+		// missed a commit
+		if sID, ok := shard.released[objKey]; ok {
+			obj.Current = &objectVersion{ LogeObj: obj, snapshotID: sID }
+			delete(shard.released, objKey)
+		}
 	}
 
-	obj.Lock.SpinLock()
-	defer obj.Lock.Unlock()
+	if obj.RefCount == 0 && typ.CachePolicy == CACHE_LRU {
+		db.lru.lock.Lock()
+		db.lru.remove(obj)
+		db.lru.lock.Unlock()
+	}
+	obj.RefCount++
 
-	db.cache[objKey] = obj	
-	db.lock.Unlock()
+	var version = obj.ensureVersion(context.SnapshotID())
+	var lv = &liveVersion{
+		version: version,
+		blob: version.Blob,
+		loaded: version.loaded,
+	}
+	shard.lock.Unlock()
 
-	obj.RefCount++
+	if !load {
+		return lv
+	}
+
+	if lv.loaded {
+		atomic.AddUint64(&db.cacheHits, 1)
+		db.metrics.Count(metric_CACHE_HITS, 1)
+		return lv
+	}
 
-	var version = obj.ensureVersion(context.getSnapshotID())
+	atomic.AddUint64(&db.cacheMisses, 1)
+	db.metrics.Count(metric_CACHE_MISSES, 1)
+	var blob = context.Get(ref)
 
-	if load && !version.loaded {
-		version.Blob = context.get(ref)
+	shard.lock.Lock()
+	if !version.loaded {
+		version.Blob = blob
 		version.loaded = true
 	}
+	lv.blob = version.Blob
+	lv.loaded = true
+	shard.lock.Unlock()
 
-	return version
+	return lv
 }
 
 
 func (db *LogeDB) releaseVersions(versions []*liveVersion) {
-	db.lock.SpinLock()
-	defer db.lock.Unlock()
+	var evicted []*logeObject
 
 	for _, lv := range versions {
 		var obj = lv.version.LogeObj
+		obj.shard.lock.Lock()
 		obj.RefCount--
+		if obj.invalid {
+			db.uncacheObject(obj)
+		}
 		if obj.RefCount == 0 {
-			delete(db.cache, obj.makeObjRef().CacheKey)
+			evicted = append(evicted, db.retainObject(obj)...)
 		}
+		obj.shard.lock.Unlock()
 	}
-}
 
+	db.evictObjects(evicted)
+}
@@ -0,0 +1,232 @@
+package loge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Expiry records live in their own type, one per expiring object
+const db_EXPIRY_TYPE = "_expiry"
+const db_REAP_BATCH = 100
+
+// Kept by later writes, which would otherwise get the type's TTL
+const db_NEVER_EXPIRES = -1
+
+type expiryRecord struct {
+	// Unix nanoseconds, or db_NEVER_EXPIRES
+	At int64
+}
+
+// As Set, with the object expiring after ttl. Zero or less never
+// expires, overriding the type's default TTL until the object is
+// deleted or given another TTL.
+func (t *Transaction) SetWithTTL(typeName string, key LogeKey, obj interface{}, ttl time.Duration) {
+	var ref = t.db.makeObjRef(typeName, key)
+	if !ref.Type.expires() {
+		panic(fmt.Sprintf("Type %s doesn't expire\n", typeName))
+	}
+
+	t.Set(typeName, key, obj)
+
+	var at int64 = db_NEVER_EXPIRES
+	if ttl > 0 {
+		at = time.Now().Add(ttl).UnixNano()
+	}
+	t.setExpiry(ref, at)
+}
+
+// Deletes expired objects with their links, and their expiry records,
+// a batch per transaction. Returns how many went.
+func (db *LogeDB) ReapExpired() int {
+	if _, ok := db.types[db_EXPIRY_TYPE]; !ok {
+		return 0
+	}
+
+	var reaped = 0
+	var from LogeKey
+	for {
+		var keys = db.ListSlice(db_EXPIRY_TYPE, from, db_REAP_BATCH)
+		if len(keys) == 0 {
+			break
+		}
+		from = keys[len(keys) - 1]
+
+		var count int
+		db.Transact(func (t *Transaction) {
+			count = t.reap(keys)
+		}, 0)
+		reaped += count
+
+		if len(keys) < db_REAP_BATCH {
+			break
+		}
+	}
+
+	if reaped > 0 {
+		db.metrics.Count(metric_EXPIRED, uint64(reaped))
+		db.logger.Debug("Reaped expired objects", "count", reaped)
+	}
+	return reaped
+}
+
+// Runs ReapExpired every interval until the DB closes
+func (db *LogeDB) StartReaper(interval time.Duration) {
+	if db.reaperStop != nil {
+		panic("Reaper already running")
+	}
+
+	db.reaperStop = make(chan struct{})
+	db.reaperDone = make(chan struct{})
+
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+		defer close(db.reaperDone)
+
+		for {
+			select {
+			case <-ticker.C:
+				db.ReapExpired()
+			case <-db.reaperStop:
+				return
+			}
+		}
+	}()
+}
+
+// -----------------------------------------------
+// Internals
+// -----------------------------------------------
+
+func (t *LogeType) expires() bool {
+	return t.TTL > 0 || t.Expires
+}
+
+func (db *LogeDB) createExpiryType() {
+	if _, ok := db.types[db_EXPIRY_TYPE]; !ok {
+		db.CreateType(NewTypeDef(db_EXPIRY_TYPE, 1, &expiryRecord{}))
+	}
+}
+
+func (db *LogeDB) stopReaper() {
+	if db.reaperStop != nil {
+		close(db.reaperStop)
+		<-db.reaperDone
+	}
+}
+
+// Type names can't contain NULs, so the first one splits the key
+func (db *LogeDB) expiryRef(ref ObjRef) ObjRef {
+	return db.makeObjRef(db_EXPIRY_TYPE, LogeKey(ref.Type.Name + "\x00" + string(ref.Key)))
+}
+
+func (t *Transaction) expired(ref ObjRef) bool {
+	var lv = t.getVersion(t.db.expiryRef(ref), false, true, false)
+	var record, _ = lv.object.(*expiryRecord)
+	return record != nil && record.At > 0 && record.At <= time.Now().UnixNano()
+}
+
+// Zero for none
+func (t *Transaction) expiryAt(ref ObjRef) int64 {
+	var lv = t.getVersion(t.db.expiryRef(ref), false, true, false)
+	if record, _ := lv.object.(*expiryRecord); record != nil {
+		return record.At
+	}
+	return 0
+}
+
+// Zero clears the expiry
+func (t *Transaction) setExpiry(ref ObjRef, at int64) {
+	var lv = t.getVersion(t.db.expiryRef(ref), true, false, false)
+	if at != 0 {
+		lv.object = &expiryRecord{ At: at }
+	} else {
+		lv.object = lv.version.LogeObj.Type.NilValue()
+	}
+}
+
+// Expired objects decode as missing. Expiry records are never JSON, so
+// they can be read the same way in any transaction.
+func (t *Transaction) decodeVersion(lv *liveVersion) (upgraded bool) {
+	var obj = lv.version.LogeObj
+	var toJSON = t.giveJSON && obj.Type.Name != db_EXPIRY_TYPE
+	lv.object, upgraded = obj.decode(lv.blob, toJSON)
+
+	if obj.LinkName == "" && obj.Type.expires() && len(lv.blob) > 0 &&
+		t.expired(obj.makeObjRef()) {
+		lv.object, _ = obj.decode(nil, toJSON)
+		upgraded = false
+	}
+	return
+}
+
+// Called before commit. Deleted objects lose their expiry, and changed
+// ones get the type's default, unless the transaction set one or it was
+// set never to expire. Without a default, a past expiry is cleared, so
+// rewriting an expired object brings it back.
+func (t *Transaction) stampExpiries() {
+	var now = time.Now()
+	for _, lv := range t.liveVersions() {
+		var obj = lv.version.LogeObj
+		if !lv.dirty || obj.LinkName != "" || !obj.Type.expires() {
+			continue
+		}
+
+		var ref = obj.makeObjRef()
+		if !obj.hasValue(lv.object) {
+			t.setExpiry(ref, 0)
+			continue
+		}
+
+		if expiry, ok := t.versions[t.db.expiryRef(ref).CacheKey]; ok && expiry.dirty {
+			continue
+		}
+		var at = t.expiryAt(ref)
+		switch {
+		case at == db_NEVER_EXPIRES:
+		case obj.Type.TTL > 0:
+			t.setExpiry(ref, now.Add(obj.Type.TTL).UnixNano())
+		case at != 0 && at <= now.UnixNano():
+			t.setExpiry(ref, 0)
+		}
+	}
+}
+
+func (t *Transaction) reap(keys []LogeKey) int {
+	var count = 0
+	var now = time.Now().UnixNano()
+
+	for _, expiryKey := range keys {
+		var lv = t.getVersion(t.db.makeObjRef(db_EXPIRY_TYPE, expiryKey), false, true, false)
+		var record, _ = lv.object.(*expiryRecord)
+		if record == nil || record.At <= 0 || record.At > now {
+			continue
+		}
+
+		var parts = strings.SplitN(string(expiryKey), "\x00", 2)
+		typ, ok := t.db.types[parts[0]]
+		if !ok || len(parts) != 2 {
+			continue
+		}
+
+		var key = LogeKey(parts[1])
+		t.Delete(typ.Name, key)
+		for linkName := range typ.Links {
+			t.SetLinks(typ.Name, linkName, key, nil)
+		}
+		count++
+	}
+
+	return count
+}
+
+func (t *ReadTransaction) expired(ref ObjRef) bool {
+	if ref.LinkName != "" || !ref.Type.expires() {
+		return false
+	}
+	var expiry = t.db.expiryRef(ref)
+	var object, _ = expiry.Type.Decode(t.readBlob(expiry), false)
+	var record, _ = object.(*expiryRecord)
+	return record != nil && record.At > 0 && record.At <= time.Now().UnixNano()
+}
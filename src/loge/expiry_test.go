@@ -0,0 +1,178 @@
+package loge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTL(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("session", 1, &TestObj{})
+	def.TTL = time.Hour
+	def.Links = LinkSpec{ "user": "session" }
+	db.CreateType(def)
+
+	db.Transact(func (t *Transaction) {
+		t.SetWithTTL("session", "one", &TestObj{ "One" }, 10 * time.Millisecond)
+		t.AddLink("session", "user", "one", "bob")
+	}, 0)
+	db.SetOne("session", "two", &TestObj{ "Two" })
+
+	if !db.ExistsOne("session", "one") {
+		test.Fatal("Object expired early")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if db.ExistsOne("session", "one") || db.ReadOne("session", "one").(*TestObj) != nil {
+		test.Error("Expired object visible to one-shot reads")
+	}
+	var t = db.CreateTransaction()
+	if t.Exists("session", "one") || t.Read("session", "one").(*TestObj) != nil {
+		test.Error("Expired object visible in transaction")
+	}
+	if t.Write("session", "one").(*TestObj) != nil {
+		test.Error("Expired object writable")
+	}
+	t.Cancel()
+	if !db.ExistsOne("session", "two") {
+		test.Error("Object with default TTL expired")
+	}
+
+	if keys := db.Find("session", "user", "bob"); len(keys) != 1 {
+		test.Errorf("Links gone before reaping: %v", keys)
+	}
+
+	if reaped := db.ReapExpired(); reaped != 1 {
+		test.Errorf("Reaped %d objects", reaped)
+	}
+	if keys := db.ListSlice("session", "", -1); len(keys) != 1 || keys[0] != "two" {
+		test.Errorf("Wrong objects after reaping: %v", keys)
+	}
+	if keys := db.Find("session", "user", "bob"); len(keys) != 0 {
+		test.Errorf("Links left after reaping: %v", keys)
+	}
+	if keys := db.ListSlice(db_EXPIRY_TYPE, "", -1); len(keys) != 1 {
+		test.Errorf("Wrong expiry records after reaping: %v", keys)
+	}
+
+	db.DeleteOne("session", "two")
+	if keys := db.ListSlice(db_EXPIRY_TYPE, "", -1); len(keys) != 0 {
+		test.Errorf("Expiry record outlived its object: %v", keys)
+	}
+}
+
+func TestSetWithTTL(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	var def = NewTypeDef("token", 1, &TestObj{})
+	def.Expires = true
+	db.CreateType(def)
+
+	db.Transact(func (t *Transaction) {
+		t.Set("token", "forever", &TestObj{ "Forever" })
+		t.SetWithTTL("token", "short", &TestObj{ "Short" }, 10 * time.Millisecond)
+		t.SetWithTTL("token", "long", &TestObj{ "Long" }, 10 * time.Millisecond)
+	}, 0)
+
+	db.Transact(func (t *Transaction) {
+		t.SetWithTTL("token", "long", &TestObj{ "Long" }, time.Hour)
+	}, 0)
+
+	time.Sleep(20 * time.Millisecond)
+
+	db.View(func (t *ReadTransaction) {
+		if !t.Exists("token", "forever") || !t.Exists("token", "long") {
+			test.Error("Object expired early")
+		}
+		if t.Exists("token", "short") || t.Read("token", "short").(*TestObj) != nil {
+			test.Error("Object didn't expire")
+		}
+	})
+
+	defer func() {
+		if recover() == nil {
+			test.Error("SetWithTTL on non-expiring type allowed")
+		}
+	}()
+	db.Transact(func (t *Transaction) {
+		t.SetWithTTL("test", "one", &TestObj{ "One" }, time.Hour)
+	}, 0)
+}
+
+func TestNeverExpires(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("session", 1, &TestObj{})
+	def.TTL = 10 * time.Millisecond
+	db.CreateType(def)
+
+	db.Transact(func (t *Transaction) {
+		t.SetWithTTL("session", "kept", &TestObj{ "Kept" }, 0)
+	}, 0)
+	db.Transact(func (t *Transaction) {
+		t.Write("session", "kept").(*TestObj).Name = "Changed"
+	}, 0)
+	db.SetOne("session", "plain", &TestObj{ "Plain" })
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !db.ExistsOne("session", "kept") {
+		test.Error("Write dropped never-expiring TTL")
+	}
+	if db.ExistsOne("session", "plain") {
+		test.Error("Object with default TTL didn't expire")
+	}
+	if reaped := db.ReapExpired(); reaped != 1 {
+		test.Errorf("Reaped %d objects", reaped)
+	}
+
+	// Recreated objects get the default again
+	db.DeleteOne("session", "kept")
+	db.SetOne("session", "kept", &TestObj{ "Again" })
+	time.Sleep(20 * time.Millisecond)
+	if db.ExistsOne("session", "kept") {
+		test.Error("Recreated object kept its old TTL")
+	}
+}
+
+func TestRewriteExpired(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("token", 1, &TestObj{})
+	def.Expires = true
+	db.CreateType(def)
+
+	db.Transact(func (t *Transaction) {
+		t.SetWithTTL("token", "one", &TestObj{ "One" }, time.Millisecond)
+	}, 0)
+	time.Sleep(5 * time.Millisecond)
+	db.SetOne("token", "one", &TestObj{ "Again" })
+
+	if !db.ExistsOne("token", "one") {
+		test.Error("Rewritten object still expired")
+	}
+	if reaped := db.ReapExpired(); reaped != 0 || !db.ExistsOne("token", "one") {
+		test.Errorf("Rewritten object reaped: %d", reaped)
+	}
+}
+
+func TestReaper(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("session", 1, &TestObj{})
+	def.TTL = time.Millisecond
+	db.CreateType(def)
+
+	for _, key := range []LogeKey{ "one", "two", "three" } {
+		db.SetOne("session", key, &TestObj{ string(key) })
+	}
+
+	db.StartReaper(5 * time.Millisecond)
+	defer db.Close()
+
+	var deadline = time.Now().Add(time.Second)
+	for len(db.ListSlice("session", "", -1)) > 0 {
+		if time.Now().After(deadline) {
+			test.Fatal("Reaper didn't delete expired objects")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
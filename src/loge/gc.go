@@ -0,0 +1,204 @@
+package loge
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const db_COLLECT_INTERVAL = 1000
+
+type snapshotSet map[uint64]int
+
+// -----------------------------------------------
+// Active snapshot tracking
+// -----------------------------------------------
+
+func (db *LogeDB) endSnapshot(sID uint64) {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	var count, ok = db.snapshots[sID]
+	if !ok {
+		return
+	}
+	if count <= 1 {
+		delete(db.snapshots, sID)
+		delete(db.pinnedAt, sID)
+	} else {
+		db.snapshots[sID] = count - 1
+	}
+}
+
+// Commits take their snapshot ID before writing to the store or the
+// cache, so until endCommit, a reader at that snapshot could miss them
+func (db *LogeDB) beginCommit() uint64 {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	var sID = atomic.AddUint64(&db.lastSnapshotID, 1)
+	db.committing[sID]++
+	return sID
+}
+
+func (db *LogeDB) endCommit(sID uint64) {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+	delete(db.committing, sID)
+}
+
+// The latest snapshot with every commit up to it complete. Reading
+// objects doesn't wait for commits, so nothing earlier is safe.
+func (db *LogeDB) beginSnapshot() uint64 {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	var sID = db.completedSnapshot()
+	if db.snapshots[sID] == 0 {
+		db.pinnedAt[sID] = time.Now()
+	}
+	db.snapshots[sID]++
+	return sID
+}
+
+// Called with the snapshot lock held
+func (db *LogeDB) completedSnapshot() uint64 {
+	var sID = atomic.LoadUint64(&db.lastSnapshotID)
+	for committing := range db.committing {
+		if committing <= sID {
+			sID = committing - 1
+		}
+	}
+	return sID
+}
+
+// The oldest snapshot an open transaction has, or a new one could get
+// while earlier commits finish
+func (db *LogeDB) OldestSnapshotID() uint64 {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	var oldest = db.completedSnapshot()
+	for sID := range db.snapshots {
+		if sID < oldest {
+			oldest = sID
+		}
+	}
+	return oldest
+}
+
+// How long the longest-held open snapshot has been held, zero for none.
+// Transactions never committed or cancelled show up here.
+func (db *LogeDB) OldestSnapshotAge() time.Duration {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	var age time.Duration
+	for _, at := range db.pinnedAt {
+		if held := time.Since(at); held > age {
+			age = held
+		}
+	}
+	return age
+}
+
+// Snapshot IDs of all open transactions, ascending. New transactions
+// start at the latest snapshot, or just before an unfinished commit, so
+// anything else is unreachable.
+func (db *LogeDB) activeSnapshots() []uint64 {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	var active = make([]uint64, 0, len(db.snapshots) + len(db.committing))
+	for sID := range db.snapshots {
+		active = append(active, sID)
+	}
+	for committing := range db.committing {
+		active = append(active, committing - 1)
+	}
+	sort.Sort(SnapshotIDs(active))
+	return active
+}
+
+// Sorted ascending
+type SnapshotIDs []uint64
+
+func (ids SnapshotIDs) Len() int { return len(ids) }
+func (ids SnapshotIDs) Less(i, j int) bool { return ids[i] < ids[j] }
+func (ids SnapshotIDs) Swap(i, j int) { ids[i], ids[j] = ids[j], ids[i] }
+
+// Whether any snapshot in [from, to) is active, i.e. whether a version
+// at from, superseded at to, can still be seen.
+func (ids SnapshotIDs) AnyIn(from uint64, to uint64) bool {
+	var i = sort.Search(len(ids), func(i int) bool { return ids[i] >= from })
+	return i < len(ids) && ids[i] < to
+}
+
+
+// -----------------------------------------------
+// Collection
+// -----------------------------------------------
+
+func (db *LogeDB) CollectVersions() int {
+	var active = db.activeSnapshots()
+	var pruned = 0
+
+	var oldest = db.OldestSnapshotID()
+	for _, shard := range db.cache {
+		shard.lock.Lock()
+		for _, obj := range shard.objects {
+			pruned += obj.pruneVersions(active)
+		}
+		for key, sID := range shard.released {
+			if sID <= oldest {
+				delete(shard.released, key)
+			}
+		}
+		shard.lock.Unlock()
+	}
+
+	pruned += db.store.CollectVersions(active)
+	db.logger.Debug("Collected versions", "pruned", pruned, "snapshots", len(active))
+	return pruned
+}
+
+func (db *LogeDB) countCommit() {
+	if atomic.AddUint64(&db.commitCount, 1) % db_COLLECT_INTERVAL == 0 {
+		db.CollectVersions()
+	}
+}
+
+// The current version is always kept, since every new transaction
+// starts at or after it.
+func (obj *logeObject) pruneVersions(active SnapshotIDs) int {
+	var kept = obj.Current
+	if kept == nil {
+		return 0
+	}
+
+	var pruned = 0
+	var supersededAt = kept.snapshotID
+	for version := kept.Previous; version != nil; version = version.Previous {
+		if active.AnyIn(version.snapshotID, supersededAt) {
+			kept.Previous = version
+			kept = version
+		} else {
+			pruned++
+		}
+		supersededAt = version.snapshotID
+	}
+
+	kept.Previous = nil
+	return pruned
+}
+
+func (mvh memVersionHistory) prune(active SnapshotIDs) memVersionHistory {
+	var last = len(mvh) - 1
+	var kept memVersionHistory
+	for i, mv := range mvh {
+		if i == last || active.AnyIn(mv.snapshotID, mvh[i+1].snapshotID) {
+			kept = append(kept, mv)
+		}
+	}
+	return kept
+}
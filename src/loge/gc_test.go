@@ -0,0 +1,121 @@
+package loge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionChainPruning(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.CachePolicy = CACHE_PERMANENT
+	db.CreateType(def)
+
+	db.SetOne("test", "one", &TestObj{Name: "Zero"})
+
+	var reader = db.CreateTransaction()
+	reader.Read("test", "one")
+
+	for i := 0; i < 20; i++ {
+		db.Transact(func (t *Transaction) {
+			t.Write("test", "one").(*TestObj).Name = "Updated"
+		}, 0)
+	}
+
+	// Commits prune as they go, bounded by the snapshots open at the time
+	var obj, _ = db.cache.get(db.makeObjRef("test", "one").CacheKey)
+	if count := countVersions(obj); count > 3 {
+		test.Errorf("Version chain not pruned on commit: %d", count)
+	}
+
+	db.CollectVersions()
+
+	if count := countVersions(obj); count != 2 {
+		test.Errorf("Wrong version count with open reader: %d", count)
+	}
+
+	if reader.Read("test", "one").(*TestObj).Name != "Zero" {
+		test.Error("Open reader lost its version")
+	}
+	reader.Commit()
+
+	db.CollectVersions()
+
+	if count := countVersions(obj); count != 1 {
+		test.Errorf("Wrong version count after reader finished: %d", count)
+	}
+}
+
+func TestMemStorePruning(test *testing.T) {
+	var store = NewMemStore().(*memStore)
+	var db = NewLogeDB(store)
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+	db.SetOne("test", "two", &TestObj{Name: "Two"})
+
+	var reader = db.CreateTransaction()
+
+	for i := 0; i < 10; i++ {
+		db.SetOne("test", "one", &TestObj{Name: "Updated"})
+	}
+	db.DeleteOne("test", "two")
+
+	db.CollectVersions()
+
+	var oneKey = db.makeObjRef("test", "one").CacheKey
+	var twoKey = db.makeObjRef("test", "two").CacheKey
+
+	if len(store.objects[oneKey]) != 2 {
+		test.Errorf("Wrong history length with open reader: %d", len(store.objects[oneKey]))
+	}
+
+	if reader.Read("test", "one").(*TestObj).Name != "One" {
+		test.Error("Open reader lost its version")
+	}
+	if reader.Read("test", "two").(*TestObj).Name != "Two" {
+		test.Error("Open reader lost deleted object")
+	}
+	reader.Commit()
+
+	db.CollectVersions()
+
+	if len(store.objects[oneKey]) != 1 {
+		test.Errorf("Wrong history length after reader finished: %d", len(store.objects[oneKey]))
+	}
+	if _, ok := store.objects[twoKey]; ok {
+		test.Error("Deleted object history not collected")
+	}
+}
+
+func countVersions(obj *logeObject) int {
+	var count = 0
+	for version := obj.Current; version != nil; version = version.Previous {
+		count++
+	}
+	return count
+}
+
+func TestOldestSnapshotAge(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	if age := db.OldestSnapshotAge(); age != 0 {
+		test.Errorf("Snapshot age with none open: %v", age)
+	}
+
+	var trans = db.CreateTransaction()
+	time.Sleep(5 * time.Millisecond)
+	db.SetOne("test", "one", &TestObj{ "One" })
+
+	db.UpdateGauges()
+	var age = db.Metrics().(*MemMetrics).Snapshot().Gauges[metric_OLDEST_SNAPSHOT_SECONDS]
+	if age < 0.005 {
+		test.Errorf("Open transaction's snapshot age: %g", age)
+	}
+
+	trans.Cancel()
+	if age := db.OldestSnapshotAge(); age != 0 {
+		test.Errorf("Snapshot age after cancel: %v", age)
+	}
+}
@@ -0,0 +1,183 @@
+package loge
+
+import (
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+type goLevelDBEngine struct {
+	db *leveldb.DB
+	writeOptions *opt.WriteOptions
+	readOptions *opt.ReadOptions
+}
+
+type goLevelDBSnapshot struct {
+	snapshot *leveldb.Snapshot
+	readOptions *opt.ReadOptions
+}
+
+type goLevelDBIterator struct {
+	it iterator.Iterator
+}
+
+// Store on goleveldb, which needs no cgo. Same on-disk format and
+// options as NewLevelDBStore.
+func NewGoLevelDBStore(basePath string) LogeStore {
+	return NewGoLevelDBStoreWithOptions(basePath, DefaultLevelDBOptions())
+}
+
+func NewGoLevelDBStoreWithOptions(basePath string, options LevelDBOptions) LogeStore {
+	var store = newLevelDBStore(basePath, options)
+
+	engine, err := openGoLevelDBEngine(basePath, options)
+	if err != nil {
+		panic(fmt.Sprintf("Can't open DB at %s: %v", basePath, err))
+	}
+
+	store.start(engine)
+	return store
+}
+
+func openGoLevelDBEngine(basePath string, options LevelDBOptions) (ldbEngine, error) {
+	var opts = &opt.Options{
+		BlockCacheCapacity: options.BlockCacheSize,
+		WriteBuffer: options.WriteBufferSize,
+		BlockSize: options.BlockSize,
+		OpenFilesCacheCapacity: options.MaxOpenFiles,
+		ErrorIfMissing: !options.CreateIfMissing,
+		ErrorIfExist: options.ErrorIfExists,
+	}
+
+	if options.Compression {
+		opts.Compression = opt.SnappyCompression
+	} else {
+		opts.Compression = opt.NoCompression
+	}
+
+	if options.BloomFilterBits > 0 {
+		opts.Filter = filter.NewBloomFilter(options.BloomFilterBits)
+	}
+
+	if options.ParanoidChecks {
+		opts.Strict = opt.StrictAll
+	}
+
+	db, err := leveldb.OpenFile(basePath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var readOptions = &opt.ReadOptions{}
+	if options.VerifyChecksums {
+		readOptions.Strict = opt.StrictBlockChecksum
+	}
+
+	return &goLevelDBEngine{
+		db: db,
+		writeOptions: &opt.WriteOptions{
+			Sync: options.Durability != DURABILITY_NONE,
+		},
+		readOptions: readOptions,
+	}, nil
+}
+
+func (engine *goLevelDBEngine) close() {
+	engine.db.Close()
+}
+
+func (engine *goLevelDBEngine) get(key []byte) ([]byte, error) {
+	return goLevelDBValue(engine.db.Get(key, engine.readOptions))
+}
+
+func (engine *goLevelDBEngine) newIterator() ldbIterator {
+	return &goLevelDBIterator{ engine.db.NewIterator(nil, engine.readOptions) }
+}
+
+func (engine *goLevelDBEngine) put(key []byte, val []byte) error {
+	return engine.db.Put(key, val, engine.writeOptions)
+}
+
+func (engine *goLevelDBEngine) write(entries []levelDBWriteEntry) error {
+	var batch = new(leveldb.Batch)
+	for _, entry := range entries {
+		if entry.Delete {
+			batch.Delete(entry.Key)
+		} else {
+			batch.Put(entry.Key, entry.Val)
+		}
+	}
+	return engine.db.Write(batch, engine.writeOptions)
+}
+
+func (engine *goLevelDBEngine) property(name string) string {
+	var value, err = engine.db.GetProperty(name)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+func (engine *goLevelDBEngine) newSnapshot() ldbSnapshot {
+	var snapshot, err = engine.db.GetSnapshot()
+	if err != nil {
+		panic(fmt.Sprintf("Snapshot error: %v\n", err))
+	}
+	return &goLevelDBSnapshot{
+		snapshot: snapshot,
+		readOptions: engine.readOptions,
+	}
+}
+
+func (snapshot *goLevelDBSnapshot) get(key []byte) ([]byte, error) {
+	return goLevelDBValue(snapshot.snapshot.Get(key, snapshot.readOptions))
+}
+
+func (snapshot *goLevelDBSnapshot) newIterator() ldbIterator {
+	return &goLevelDBIterator{ snapshot.snapshot.NewIterator(nil, snapshot.readOptions) }
+}
+
+func (snapshot *goLevelDBSnapshot) release() {
+	snapshot.snapshot.Release()
+}
+
+// levigo returns nil for missing keys, and the store relies on that
+func goLevelDBValue(val []byte, err error) ([]byte, error) {
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return val, err
+}
+
+
+// -----------------------------------------------
+// Iterator
+// -----------------------------------------------
+
+func (it *goLevelDBIterator) Seek(key []byte) {
+	it.it.Seek(key)
+}
+
+func (it *goLevelDBIterator) Valid() bool {
+	return it.it.Valid()
+}
+
+func (it *goLevelDBIterator) Next() {
+	it.it.Next()
+}
+
+// goleveldb reuses its buffers on Next, where levigo copies
+func (it *goLevelDBIterator) Key() []byte {
+	return append([]byte{}, it.it.Key()...)
+}
+
+func (it *goLevelDBIterator) Value() []byte {
+	return append([]byte{}, it.it.Value()...)
+}
+
+func (it *goLevelDBIterator) Close() {
+	it.it.Release()
+}
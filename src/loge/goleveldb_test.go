@@ -0,0 +1,88 @@
+package loge
+
+import (
+	"testing"
+	"os"
+	"reflect"
+)
+
+func TestGoLevelDBStore(test *testing.T) {
+	var dir = test.TempDir()
+	var db = openStoreTest(NewGoLevelDBStore(dir))
+
+	db.Transact(func (t *Transaction) {
+		t.Set("test", "one", &TestObj{ "One" })
+		t.Set("test", "two", &TestObj{ "Two" })
+		t.Set("test", "three", &TestObj{ "Three" })
+		t.AddLink("test", "other", "one", "two")
+		t.AddLink("test", "other", "two", "one")
+	}, 0)
+
+	var reader = db.CreateTransaction()
+
+	db.SetOne("test", "one", &TestObj{ "Updated" })
+	db.DeleteOne("test", "three")
+
+	if reader.Read("test", "one").(*TestObj).Name != "One" {
+		test.Error("Snapshot saw later update")
+	}
+	if !reader.Exists("test", "three") {
+		test.Error("Snapshot saw later delete")
+	}
+	reader.Commit()
+
+	if db.ReadOne("test", "one").(*TestObj).Name != "Updated" {
+		test.Error("Update not visible")
+	}
+	if db.ExistsOne("test", "three") {
+		test.Error("Deleted object exists")
+	}
+
+	var found = db.Find("test", "other", "two")
+	if !reflect.DeepEqual(found, []LogeKey{ "one" }) {
+		test.Errorf("Wrong find results: %v", found)
+	}
+
+	var listed = db.ListSlice("test", "", -1)
+	if !reflect.DeepEqual(listed, []LogeKey{ "one", "two" }) {
+		test.Errorf("Wrong list results: %v", listed)
+	}
+
+	db.Close()
+
+	db = openStoreTest(NewGoLevelDBStore(dir))
+	defer db.Close()
+
+	if db.ReadOne("test", "two").(*TestObj).Name != "Two" {
+		test.Error("Object lost on reopen")
+	}
+	if !reflect.DeepEqual(db.ReadLinksOne("test", "other", "one"), []string{ "two" }) {
+		test.Error("Links lost on reopen")
+	}
+}
+
+func TestGoLevelDBMissingDir(test *testing.T) {
+	var opts = DefaultLevelDBOptions()
+	opts.CreateIfMissing = false
+
+	defer func() {
+		if recover() == nil {
+			test.Error("Opened missing DB without CreateIfMissing")
+		}
+	}()
+
+	NewGoLevelDBStoreWithOptions(test.TempDir() + string(os.PathSeparator) + "missing", opts)
+}
+
+// A DB with a linked "test" type, plus any others given, for the store
+// tests which reopen
+func openStoreTest(store LogeStore, defs ...*TypeDef) *LogeDB {
+	var db = NewLogeDB(store)
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Links = LinkSpec{ "other": "test" }
+	db.CreateType(def)
+	for _, def := range defs {
+		db.CreateType(def)
+	}
+	return db
+}
@@ -0,0 +1,118 @@
+package loge
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// One retained version of an object. Object is nil where it was deleted.
+type HistoryEntry struct {
+	SnapshotID uint64
+	Time time.Time
+	Object interface{}
+}
+
+// Retained versions of an object, oldest first. Needs KeepVersions on
+// the type.
+func (db *LogeDB) History(typeName string, key LogeKey) []HistoryEntry {
+	var ref = db.makeObjRef(typeName, key)
+	var entries = make([]HistoryEntry, 0)
+
+	db.View(func (t *ReadTransaction) {
+		t.checkOpen()
+		var prefix = historyPrefix(ref)
+		for _, seq := range t.context.ListSlice(prefix, "", -1).All() {
+			var val = t.context.Get(historyRef(prefix, seq))
+			if len(val) < 16 {
+				continue
+			}
+			var entry = HistoryEntry{
+				SnapshotID: binary.BigEndian.Uint64(val[0:8]),
+				Time: time.Unix(0, int64(binary.BigEndian.Uint64(val[8:16]))),
+			}
+			if len(val) > 16 {
+				entry.Object, _ = ref.Type.Decode(val[16:], false)
+			}
+			entries = append(entries, entry)
+		}
+	})
+
+	return entries
+}
+
+// The object as of snapshot sID. False if that's before every retained
+// version.
+func (db *LogeDB) ReadAt(typeName string, key LogeKey, sID uint64) (interface{}, bool) {
+	return db.readHistory(typeName, key, func(entry HistoryEntry) bool {
+		return entry.SnapshotID <= sID
+	})
+}
+
+// The object as of the given time, as ReadAt
+func (db *LogeDB) ReadAtTime(typeName string, key LogeKey, at time.Time) (interface{}, bool) {
+	return db.readHistory(typeName, key, func(entry HistoryEntry) bool {
+		return !entry.Time.After(at)
+	})
+}
+
+// -----------------------------------------------
+// Internals
+// -----------------------------------------------
+
+func (db *LogeDB) readHistory(typeName string, key LogeKey, visible func(HistoryEntry) bool) (interface{}, bool) {
+	var entries = db.History(typeName, key)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if visible(entries[i]) {
+			return entries[i].Object, true
+		}
+	}
+	return nil, false
+}
+
+// Called at commit with the object locked, so earlier commits of it
+// are all in the store. Entries are numbered per object, in commit
+// order, and each records its snapshot ID for ReadAt. Those only stay
+// in order across restarts on a SnapshotStore.
+func (t *Transaction) recordHistory(obj *logeObject, blob []byte, sID uint64, now time.Time) {
+	var prefix = historyPrefix(obj.makeObjRef())
+
+	var reader = t.db.store.NewContext(atomic.LoadUint64(&t.db.lastSnapshotID))
+	var existing = reader.ListSlice(prefix, "", -1).All()
+	reader.Rollback()
+
+	var seq uint64
+	if len(existing) > 0 {
+		seq = binary.BigEndian.Uint64([]byte(existing[len(existing) - 1])) + 1
+	}
+
+	var val = make([]byte, 16, 16 + len(blob))
+	binary.BigEndian.PutUint64(val[0:8], sID)
+	binary.BigEndian.PutUint64(val[8:16], uint64(now.UnixNano()))
+	val = append(val, blob...)
+	t.context.Store(historyRef(prefix, encodeHistorySeq(seq)), val)
+
+	var excess = len(existing) + 1 - obj.Type.KeepVersions
+	for i := 0; i < excess; i++ {
+		t.context.Store(historyRef(prefix, existing[i]), nil)
+	}
+}
+
+// The key's length goes first, so no object's prefix covers another's
+func historyPrefix(ref ObjRef) []byte {
+	var prefix = make([]byte, 2, 4 + len(ref.CacheKey))
+	binary.BigEndian.PutUint16(prefix, ldb_HISTORY_TAG)
+	prefix = binary.AppendUvarint(prefix, uint64(len(ref.CacheKey)))
+	return append(prefix, ref.CacheKey...)
+}
+
+func encodeHistorySeq(seq uint64) LogeKey {
+	var buf = make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return LogeKey(buf)
+}
+
+// Stores only look at CacheKey
+func historyRef(prefix []byte, seq LogeKey) ObjRef {
+	return ObjRef{ CacheKey: string(prefix) + string(seq) }
+}
@@ -0,0 +1,90 @@
+package loge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	var def = NewTypeDef("kept", 1, &TestObj{})
+	def.KeepVersions = 3
+	db.CreateType(def)
+
+	for _, name := range []string{ "One", "Two", "Three", "Four" } {
+		db.SetOne("kept", "obj", &TestObj{ name })
+		db.SetOne("kept", "other", &TestObj{ name })
+		db.SetOne("test", "obj", &TestObj{ name })
+	}
+	db.DeleteOne("kept", "obj")
+
+	var history = db.History("kept", "obj")
+	if len(history) != 3 {
+		test.Fatalf("Wrong history length: %d", len(history))
+	}
+	if history[0].Object.(*TestObj).Name != "Three" ||
+		history[1].Object.(*TestObj).Name != "Four" || history[2].Object != nil {
+		test.Errorf("Wrong history: %v", history)
+	}
+	for i := 1; i < len(history); i++ {
+		if history[i].SnapshotID <= history[i-1].SnapshotID || history[i].Time.Before(history[i-1].Time) {
+			test.Errorf("History out of order: %v", history)
+		}
+	}
+
+	if obj, ok := db.ReadAt("kept", "obj", history[1].SnapshotID + 1); !ok || obj.(*TestObj).Name != "Four" {
+		test.Errorf("Wrong object at snapshot: %v, %v", obj, ok)
+	}
+	if obj, ok := db.ReadAt("kept", "obj", history[2].SnapshotID); !ok || obj != nil {
+		test.Errorf("Deleted object at snapshot: %v, %v", obj, ok)
+	}
+	if _, ok := db.ReadAt("kept", "obj", history[0].SnapshotID - 1); ok {
+		test.Error("Read before retained history")
+	}
+	if obj, ok := db.ReadAtTime("kept", "obj", history[0].Time); !ok || obj.(*TestObj).Name != "Three" {
+		test.Errorf("Wrong object at time: %v, %v", obj, ok)
+	}
+	if _, ok := db.ReadAtTime("kept", "obj", history[0].Time.Add(-time.Second)); ok {
+		test.Error("Read before retained history by time")
+	}
+
+	if len(db.History("kept", "other")) != 3 {
+		test.Error("History not kept per object")
+	}
+	if len(db.History("test", "obj")) != 0 {
+		test.Error("History kept without KeepVersions")
+	}
+}
+
+func TestHistoryReopen(test *testing.T) {
+	var kept = NewTypeDef("kept", 1, &TestObj{})
+	kept.KeepVersions = 5
+
+	for name, open := range map[string]func(string) LogeStore{
+		"goleveldb": NewGoLevelDBStore,
+		"memstore": NewPersistentMemStore,
+	} {
+		var dir = test.TempDir()
+		var db = openStoreTest(open(dir), kept)
+		db.SetOne("kept", "obj", &TestObj{ "One" })
+		db.SetOne("kept", "obj", &TestObj{ "Two" })
+		db.Close()
+
+		db = openStoreTest(open(dir), kept)
+		db.SetOne("kept", "obj", &TestObj{ "Three" })
+
+		var history = db.History("kept", "obj")
+		if len(history) != 3 || history[2].Object.(*TestObj).Name != "Three" {
+			test.Fatalf("%s: Wrong history after reopen: %v", name, history)
+		}
+		if history[2].SnapshotID <= history[1].SnapshotID {
+			test.Errorf("%s: Snapshot IDs went backwards: %d after %d",
+				name, history[2].SnapshotID, history[1].SnapshotID)
+		}
+		if obj, _ := db.ReadAt("kept", "obj", history[1].SnapshotID); obj.(*TestObj).Name != "Two" {
+			test.Errorf("%s: Wrong object before reopen: %v", name, obj)
+		}
+		db.Close()
+	}
+}
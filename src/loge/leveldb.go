@@ -5,24 +5,60 @@ import (
 	"bytes"
 	"encoding/binary"
 	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/brendonh/spack"
-	"github.com/jmhodges/levigo"
 )
 
-const ldb_LINK_TAG uint16 = 2
-const ldb_LINK_INFO_TAG uint16 = 3
-const ldb_INDEX_TAG uint16 = 4
-const ldb_START_TAG uint16 = 8
+const ldb_NUM_LEVELS = 7
+const ldb_DEFAULT_BATCH_SIZE = 128
+
+type Durability int
+
+const (
+	// Writes reach the OS before commit returns, but aren't fsynced
+	DURABILITY_NONE Durability = iota
+	// Every commit is written and fsynced alone, before it returns
+	DURABILITY_SYNC
+	// Commits batched together share one fsynced write
+	DURABILITY_GROUP
+)
+
+func (d Durability) String() string {
+	switch d {
+	case DURABILITY_NONE:
+		return "NONE"
+	case DURABILITY_SYNC:
+		return "SYNC"
+	case DURABILITY_GROUP:
+		return "GROUP"
+	}
+	return "UNKNOWN DURABILITY"
+}
 
 
 type levelDBStore struct {
 	basePath string
-	db *levigo.DB
+	engine ldbEngine
 	types *spack.TypeSet
 
+	durability Durability
+	maxBatchSize int
+	maxBatchLatency time.Duration
+
+	changeLog bool
+	changeRetention uint64
+	lastLogged uint64
+	prunedThrough uint64
+
 	writeQueue chan *levelDBContext
-	flushed bool
+	queueDepth int32
+	flushed chan bool
+
+	logger Logger
 }
 
 type levelDBResultSet struct {
@@ -36,11 +72,12 @@ type levelDBResultSet struct {
 
 type levelDBContext struct {
 	ldbStore *levelDBStore
-	snapshot *levigo.Snapshot
+	snapshot ldbSnapshot
 	snapshotID uint64
-	readOptions *levigo.ReadOptions
 	batch []levelDBWriteEntry
 	result chan error
+	commitID uint64
+	changes uint32
 }
 
 type levelDBWriteEntry struct {
@@ -49,73 +86,168 @@ type levelDBWriteEntry struct {
 	Delete bool
 }
 
-var defaultWriteOptions = levigo.NewWriteOptions()
-var defaultReadOptions = levigo.NewReadOptions()
+// The parts of LevelDB the store uses, so it can sit on either levigo
+// or a pure-Go implementation. Gets return nil for missing keys.
+type ldbEngine interface {
+	ldbReader
+	newSnapshot() ldbSnapshot
+	put(key []byte, val []byte) error
+	write(entries []levelDBWriteEntry) error
+	property(name string) string
+	close()
+}
+
+type ldbReader interface {
+	get(key []byte) ([]byte, error)
+	newIterator() ldbIterator
+}
+
+type ldbSnapshot interface {
+	ldbReader
+	release()
+}
+
+type ldbIterator interface {
+	Seek(key []byte)
+	Valid() bool
+	Next()
+	Key() []byte
+	Value() []byte
+	Close()
+}
+
+type LevelDBOptions struct {
+	// Size in bytes of the LRU cache for uncompressed blocks. Zero
+	// leaves LevelDB's default (8MB).
+	BlockCacheSize int
+	// Bytes to buffer in memory before writing a sorted table. Zero
+	// leaves LevelDB's default (4MB).
+	WriteBufferSize int
+	// Approximate on-disk block size. Zero leaves LevelDB's default (4KB).
+	BlockSize int
+	// Bits per key for a bloom filter on point reads. Zero disables it.
+	BloomFilterBits int
+	// Snappy compression of blocks
+	Compression bool
+	// Zero leaves LevelDB's default (1000)
+	MaxOpenFiles int
+	ParanoidChecks bool
+	VerifyChecksums bool
+	Durability Durability
+	// Most commits the writer merges into one LevelDB write. Ignored
+	// (always 1) with DURABILITY_SYNC.
+	MaxBatchSize int
+	// How long the writer waits for more commits to join a batch. Zero
+	// only merges commits which are already queued.
+	MaxBatchLatency time.Duration
+	// Keep a durable log of committed changes, for LogeDB.ChangesSince
+	ChangeLog bool
+	// Snapshots of changes to keep in the log. Zero keeps everything.
+	ChangeRetention uint64
+	CreateIfMissing bool
+	ErrorIfExists bool
+
+	// Defaults to warnings and errors on stderr. Replaced by the DB's
+	// logger if one is set in its DBOptions.
+	Logger Logger
+}
+
+func DefaultLevelDBOptions() LevelDBOptions {
+	return LevelDBOptions{
+		Compression: true,
+		MaxBatchSize: ldb_DEFAULT_BATCH_SIZE,
+		CreateIfMissing: true,
+	}
+}
 
+// LevelDB via levigo, which needs cgo. See NewGoLevelDBStore for a
+// pure-Go alternative using the same on-disk format.
 func NewLevelDBStore(basePath string) LogeStore {
+	return NewLevelDBStoreWithOptions(basePath, DefaultLevelDBOptions())
+}
 
-	var opts = levigo.NewOptions()
-	opts.SetCreateIfMissing(true)
-	db, err := levigo.Open(basePath, opts)
+func NewLevelDBStoreWithOptions(basePath string, options LevelDBOptions) LogeStore {
+	var store = newLevelDBStore(basePath, options)
 
+	engine, err := openLevigoEngine(basePath, options)
 	if err != nil {
 		panic(fmt.Sprintf("Can't open DB at %s: %v", basePath, err))
 	}
 
+	store.start(engine)
+	return store
+}
+
+func newLevelDBStore(basePath string, options LevelDBOptions) *levelDBStore {
+	if options.Logger == nil {
+		options.Logger = defaultLogger()
+	}
+
 	var store = &levelDBStore {
 		basePath: basePath,
-		db: db,
-		types: spack.NewTypeSet(),
-		
-		writeQueue: make(chan *levelDBContext),
-		flushed: false,
+		types: newMetadataTypeSet(),
+		durability: options.Durability,
+		maxBatchSize: options.MaxBatchSize,
+		maxBatchLatency: options.MaxBatchLatency,
+		changeLog: options.ChangeLog,
+		changeRetention: options.ChangeRetention,
+
+		flushed: make(chan bool),
+
+		logger: options.Logger,
 	}
 
-	store.types.LastTag = ldb_START_TAG
-	store.loadTypeMetadata()
-	go store.writer()
+	if store.maxBatchSize < 1 || options.Durability == DURABILITY_SYNC {
+		store.maxBatchSize = 1
+	}
+	store.writeQueue = make(chan *levelDBContext, store.maxBatchSize)
 
 	return store
 }
 
-func (store *levelDBStore) close() {
-	store.writeQueue <- nil
-	for !store.flushed {
-		runtime.Gosched()
-	}
-	store.db.Close()
+func (store *levelDBStore) start(engine ldbEngine) {
+	store.engine = engine
+	loadTypeMetadata(store.types, store)
+	store.loadChangeLog()
+	go store.writer()
 }
 
-func (store *levelDBStore) registerType(typ *logeType) {
-	store.tagVersions(typ)
+func (store *levelDBStore) Close() {
+	store.writeQueue <- nil
+	<-store.flushed
+	store.engine.close()
+}
 
-	var vt = typ.SpackType
+func (store *levelDBStore) RegisterType(typ *LogeType) {
+	registerTypeMetadata(store.types, store, typ, store.logger)
+}
 
-	if (!vt.Dirty) {
-		return
-	}
+func (store *levelDBStore) GetSpackType(name string) *spack.VersionedType {
+	return store.types.RegisterType(name)
+}
 
-	fmt.Printf("Updating type info: %s (%d)\n", typ.Name, typ.Version)
+func (store *levelDBStore) CollectVersions(active SnapshotIDs) int {
+	// LevelDB only keeps the latest version, and snapshots handle the rest
+	return 0
+}
 
-	var typeType = store.types.Type("_type")
-	var keyVal = typeType.EncodeKey(vt.Name)
-	var typeVal, err = typeType.EncodeObj(vt)
+func (store *levelDBStore) SetLogger(logger Logger) {
+	store.logger = logger
+}
 
-	if err != nil {
-		panic(fmt.Sprintf("Error encoding type %s: %v", vt.Name, err))
+func (store *levelDBStore) Stats() map[string]float64 {
+	var stats = map[string]float64{
+		"loge_leveldb_write_queue_depth": float64(atomic.LoadInt32(&store.queueDepth)),
 	}
 
-	err = store.db.Put(defaultWriteOptions, keyVal, typeVal)
-	
-	if err != nil {
-		panic(fmt.Sprintf("Couldn't write type metadata: %v\n", err))
+	for level := 0; level < ldb_NUM_LEVELS; level++ {
+		var prop = store.engine.property(fmt.Sprintf("leveldb.num-files-at-level%d", level))
+		if files, err := strconv.Atoi(prop); err == nil {
+			stats[fmt.Sprintf("loge_leveldb_files{level=\"%d\"}", level)] = float64(files)
+		}
 	}
 
-	vt.Dirty = false
-}
-
-func (store *levelDBStore) getSpackType(name string) *spack.VersionedType {
-	return store.types.RegisterType(name)
+	return stats
 }
 
 
@@ -165,14 +297,10 @@ func (rs *levelDBResultSet) Close() {
 // Transaction Contexts
 // -----------------------------------------------
 
-func (store *levelDBStore) newContext(sID uint64) transactionContext {
-	var snapshot = store.db.NewSnapshot()
-	var options = levigo.NewReadOptions()
-	options.SetSnapshot(snapshot)
+func (store *levelDBStore) NewContext(sID uint64) TransactionContext {
 	return &levelDBContext{
 		ldbStore: store,
-		readOptions: options,
-		snapshot: snapshot,
+		snapshot: store.engine.newSnapshot(),
 		snapshotID: sID,
 		batch: make([]levelDBWriteEntry, 0),
 		result: make(chan error),
@@ -182,57 +310,118 @@ func (store *levelDBStore) newContext(sID uint64) transactionContext {
 func (store *levelDBStore) writer() {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
-	for context := range store.writeQueue {
+	for {
+		var batch, closing = store.nextBatch()
+
+		if len(batch) > 0 {
+			var err = store.writeBatch(batch)
+			for _, context := range batch {
+				context.result<- err
+			}
+			if err == nil {
+				store.pruneChanges()
+			}
+		}
+
+		if closing {
+			break
+		}
+	}
+	close(store.flushed)
+}
+
+// Blocks for one commit, then collects any others already queued (or
+// arriving within maxBatchLatency) up to maxBatchSize.
+func (store *levelDBStore) nextBatch() ([]*levelDBContext, bool) {
+	var first = <-store.writeQueue
+	if first == nil {
+		return nil, true
+	}
+	atomic.AddInt32(&store.queueDepth, -1)
+
+	var batch = []*levelDBContext{ first }
+	var closing = false
+
+	var deadline <-chan time.Time
+	if store.maxBatchLatency > 0 {
+		var timer = time.NewTimer(store.maxBatchLatency)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+collect:
+	for len(batch) < store.maxBatchSize {
+		var context *levelDBContext
+		if deadline == nil {
+			select {
+			case context = <-store.writeQueue:
+			default:
+				break collect
+			}
+		} else {
+			select {
+			case context = <-store.writeQueue:
+			case <-deadline:
+				break collect
+			}
+		}
+
 		if context == nil {
+			closing = true
 			break
 		}
-		context.result<- context.Write()
+		atomic.AddInt32(&store.queueDepth, -1)
+		batch = append(batch, context)
+	}
+
+	sort.Sort(contextsBySnapshot(batch))
+	return batch, closing
+}
+
+func (store *levelDBStore) writeBatch(batch []*levelDBContext) error {
+	var entries = make([]levelDBWriteEntry, 0, len(batch[0].batch) * len(batch))
+	for _, context := range batch {
+		entries = append(entries, context.batch...)
 	}
-	store.flushed = true
+	entries = append(entries, store.markLogged(batch)...)
+	return store.engine.write(entries)
 }
 
+type contextsBySnapshot []*levelDBContext
+
+func (cs contextsBySnapshot) Len() int { return len(cs) }
+func (cs contextsBySnapshot) Less(i, j int) bool { return cs[i].commitID < cs[j].commitID }
+func (cs contextsBySnapshot) Swap(i, j int) { cs[i], cs[j] = cs[j], cs[i] }
 
-func (context *levelDBContext) getSnapshotID() uint64 {
+
+func (context *levelDBContext) SnapshotID() uint64 {
 	return context.snapshotID
 }
 
-func (context *levelDBContext) commit(sID uint64) error {
+func (context *levelDBContext) Commit(sID uint64) error {
+	context.commitID = sID
+	atomic.AddInt32(&context.ldbStore.queueDepth, 1)
 	context.ldbStore.writeQueue <- context
 	var err = <-context.result
 	context.cleanup()
 	return err
 }
 
-func (context *levelDBContext) rollback() {
+func (context *levelDBContext) Rollback() {
 	context.cleanup()
 }
 
 func (context *levelDBContext) cleanup() {
-	context.ldbStore.db.ReleaseSnapshot(context.snapshot)
-	context.readOptions.Close()
-}
-
-func (context *levelDBContext) Write() error {
-	var wb = levigo.NewWriteBatch()
-	defer wb.Close()
-	for _, entry := range context.batch {
-		if entry.Delete {
-			wb.Delete(entry.Key)
-		} else {
-			wb.Put(entry.Key, entry.Val)
-		}
-	}
-
-	return context.ldbStore.db.Write(defaultWriteOptions, wb)
+	context.snapshot.release()
 }
 
 
 // -----------------------------------------------
-// transactionContext API
+// TransactionContext API
 // -----------------------------------------------
 
-func (context *levelDBContext) get(ref objRef) []byte {
-	val, err := context.ldbStore.db.Get(context.readOptions, []byte(ref.CacheKey))
+func (context *levelDBContext) Get(ref ObjRef) []byte {
+	val, err := context.snapshot.get([]byte(ref.CacheKey))
 
 	if err != nil {
 		panic(fmt.Sprintf("Read error: %v\n", err))
@@ -241,7 +430,7 @@ func (context *levelDBContext) get(ref objRef) []byte {
 	return val
 }
 
-func (context *levelDBContext) store(ref objRef, enc []byte) error {
+func (context *levelDBContext) Store(ref ObjRef, enc []byte) error {
 	var key = []byte(ref.CacheKey)
 
 	if len(enc) == 0 {
@@ -254,21 +443,21 @@ func (context *levelDBContext) store(ref objRef, enc []byte) error {
 	return nil
 }
 
-func (context *levelDBContext) addIndex(ref objRef, source LogeKey) {
+func (context *levelDBContext) AddIndex(ref ObjRef, source LogeKey) {
 	var key = encodeIndexKey(ref, source)
 	context.put(key, []byte{})
 }
 
-func (context *levelDBContext) remIndex(ref objRef, source LogeKey) {
+func (context *levelDBContext) RemoveIndex(ref ObjRef, source LogeKey) {
 	var key = encodeIndexKey(ref, source)
 	context.delete(key)
 }
 
-func (context *levelDBContext) find(ref objRef) ResultSet {
-	return context.findSlice(ref, "", -1)
+func (context *levelDBContext) Find(ref ObjRef) ResultSet {
+	return context.FindSlice(ref, "", -1)
 }
 
-func (context *levelDBContext) findSlice(ref objRef, from LogeKey, limit int) ResultSet {
+func (context *levelDBContext) FindSlice(ref ObjRef, from LogeKey, limit int) ResultSet {
 	if limit == 0 {
 		return &levelDBResultSet {
 			closed: true,
@@ -279,7 +468,7 @@ func (context *levelDBContext) findSlice(ref objRef, from LogeKey, limit int) Re
 		encodeLDBKey(ldb_INDEX_TAG, ref),
 		0)
 
-	var it = context.ldbStore.iteratePrefix(prefix, []byte(from), context.readOptions)
+	var it = iteratePrefix(context.snapshot, prefix, []byte(from))
 	if !it.Valid() {
 		it.Close()
 		return &levelDBResultSet {
@@ -301,14 +490,14 @@ func (context *levelDBContext) findSlice(ref objRef, from LogeKey, limit int) Re
 }
 
 
-func (context *levelDBContext) listSlice(prefix []byte, from LogeKey, limit int) ResultSet {
+func (context *levelDBContext) ListSlice(prefix []byte, from LogeKey, limit int) ResultSet {
 	if limit == 0 {
 		return &levelDBResultSet {
 			closed: true,
 		}
 	}
 
-	var it = context.ldbStore.iteratePrefix(prefix, []byte(from), context.readOptions)
+	var it = iteratePrefix(context.snapshot, prefix, []byte(from))
 	if !it.Valid() {
 		it.Close()
 		return &levelDBResultSet {
@@ -347,66 +536,24 @@ func (context *levelDBContext) delete(key []byte) error {
 // Internals
 // -----------------------------------------------
 
-func (store *levelDBStore) loadTypeMetadata() {
-	var typeType = store.types.Type("_type")
-	var tag = typeType.EncodeTag()
-	var it = store.iteratePrefix(tag, []byte{}, defaultReadOptions)
+func (store *levelDBStore) iterateMetadata(prefix []byte, each func(val []byte)) {
+	var it = iteratePrefix(store.engine, prefix, []byte{})
 	defer it.Close()
 
-	for it = it; it.Valid(); it.Next() {
-		var typeInfo, _, err = typeType.DecodeObj(it.Value(), false)
-
-		if err != nil {
-			panic(fmt.Sprintf("Error loading type info: %v", err))
-		}
-
-		store.types.LoadType(typeInfo.(*spack.VersionedType))
+	for ; it.Valid(); it.Next() {
+		each(it.Value())
 	}
 }
 
-func (store *levelDBStore) tagVersions(typ *logeType) {
-	var vt = typ.SpackType
-	var prefix = encodeTaggedKey([]uint16{ldb_LINK_INFO_TAG, vt.Tag}, "")
-	var it = store.iteratePrefix(prefix, []byte{}, defaultReadOptions)
-	defer it.Close()
-
-	for it = it; it.Valid(); it.Next() {
-		var info = &linkInfo{}
-		spack.DecodeFromBytes(info, linkInfoSpec, it.Value())
-		typ.Links[info.Name] = info
-	}
-
-	var maxTag uint16 = 0;
-	var missing = make([]*linkInfo, 0)
-
-	for _, info := range typ.Links {
-		if info.Tag > maxTag {
-			maxTag = info.Tag
-		}
-		if info.Tag == 0 {
-			missing = append(missing, info)
-		}
-	}
-
-	for _, info := range missing {
-		maxTag++
-		info.Tag = maxTag
-		var key = encodeTaggedKey([]uint16{ldb_LINK_INFO_TAG, vt.Tag}, info.Name)
-		enc, _ := spack.EncodeToBytes(info, linkInfoSpec)
-		fmt.Printf("Updating link: %s::%s (%d)\n", typ.Name, info.Name, info.Tag)
-		var err = store.db.Put(defaultWriteOptions, key, enc)
-		if err != nil {
-			panic(fmt.Sprintf("Write error: %v\n", err))
-		}
-	}
-
+func (store *levelDBStore) putMetadata(key []byte, val []byte) error {
+	return store.engine.put(key, val)
 }
 
 // -----------------------------------------------
 // Key encoding
 // -----------------------------------------------
 
-func encodeLDBKey(typeTag uint16, ref objRef) []byte {
+func encodeLDBKey(typeTag uint16, ref ObjRef) []byte {
 	var keyBytes = []byte(ref.CacheKey)
 	var buf = bytes.NewBuffer(make([]byte, 0, len(keyBytes) + 2))
 	binary.Write(buf, binary.BigEndian, typeTag)
@@ -424,7 +571,7 @@ func encodeTaggedKey(tags []uint16, key string) []byte {
 	return buf.Bytes()
 }
 
-func encodeIndexKey(ref objRef, target LogeKey) []byte {
+func encodeIndexKey(ref ObjRef, target LogeKey) []byte {
 	var targetBytes = []byte(ref.CacheKey)
 	var sourceBytes = []byte(target)
 	var buf = bytes.NewBuffer(make([]byte, 0, 3 + len(targetBytes) + len(sourceBytes)))
@@ -442,12 +589,12 @@ func encodeIndexKey(ref objRef, target LogeKey) []byte {
 
 type prefixIterator struct {
 	Prefix []byte
-	Iterator *levigo.Iterator
+	Iterator ldbIterator
 	Finished bool
 }
 
-func (store *levelDBStore) iteratePrefix(prefix []byte, from []byte, readOptions *levigo.ReadOptions) *prefixIterator {
-	var it = store.db.NewIterator(readOptions)
+func iteratePrefix(reader ldbReader, prefix []byte, from []byte) *prefixIterator {
+	var it = reader.newIterator()
 	var seekPrefix = append(prefix, from...)
 	it.Seek(seekPrefix)
 
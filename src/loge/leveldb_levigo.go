@@ -0,0 +1,151 @@
+//go:build cgo
+// +build cgo
+
+package loge
+
+import (
+	"github.com/jmhodges/levigo"
+)
+
+type levigoEngine struct {
+	db *levigo.DB
+	options *levigo.Options
+	cache *levigo.Cache
+	filterPolicy *levigo.FilterPolicy
+	writeOptions *levigo.WriteOptions
+	readOptions *levigo.ReadOptions
+	verifyChecksums bool
+}
+
+type levigoSnapshot struct {
+	engine *levigoEngine
+	snapshot *levigo.Snapshot
+	readOptions *levigo.ReadOptions
+}
+
+func openLevigoEngine(basePath string, options LevelDBOptions) (ldbEngine, error) {
+	var engine = &levigoEngine{}
+	engine.configure(options)
+
+	db, err := levigo.Open(basePath, engine.options)
+	if err != nil {
+		engine.closeOptions()
+		return nil, err
+	}
+
+	engine.db = db
+	return engine, nil
+}
+
+func (engine *levigoEngine) configure(options LevelDBOptions) {
+	var opts = levigo.NewOptions()
+	opts.SetCreateIfMissing(options.CreateIfMissing)
+	opts.SetErrorIfExists(options.ErrorIfExists)
+	opts.SetParanoidChecks(options.ParanoidChecks)
+
+	if options.Compression {
+		opts.SetCompression(levigo.SnappyCompression)
+	} else {
+		opts.SetCompression(levigo.NoCompression)
+	}
+
+	if options.BlockCacheSize > 0 {
+		engine.cache = levigo.NewLRUCache(options.BlockCacheSize)
+		opts.SetCache(engine.cache)
+	}
+
+	if options.BloomFilterBits > 0 {
+		engine.filterPolicy = levigo.NewBloomFilter(options.BloomFilterBits)
+		opts.SetFilterPolicy(engine.filterPolicy)
+	}
+
+	if options.WriteBufferSize > 0 {
+		opts.SetWriteBufferSize(options.WriteBufferSize)
+	}
+	if options.BlockSize > 0 {
+		opts.SetBlockSize(options.BlockSize)
+	}
+	if options.MaxOpenFiles > 0 {
+		opts.SetMaxOpenFiles(options.MaxOpenFiles)
+	}
+
+	engine.options = opts
+
+	engine.writeOptions = levigo.NewWriteOptions()
+	engine.writeOptions.SetSync(options.Durability != DURABILITY_NONE)
+
+	engine.readOptions = levigo.NewReadOptions()
+	engine.readOptions.SetVerifyChecksums(options.VerifyChecksums)
+	engine.verifyChecksums = options.VerifyChecksums
+}
+
+func (engine *levigoEngine) close() {
+	engine.db.Close()
+	engine.closeOptions()
+}
+
+func (engine *levigoEngine) closeOptions() {
+	engine.writeOptions.Close()
+	engine.readOptions.Close()
+	engine.options.Close()
+	if engine.cache != nil {
+		engine.cache.Close()
+	}
+	if engine.filterPolicy != nil {
+		engine.filterPolicy.Close()
+	}
+}
+
+func (engine *levigoEngine) get(key []byte) ([]byte, error) {
+	return engine.db.Get(engine.readOptions, key)
+}
+
+func (engine *levigoEngine) newIterator() ldbIterator {
+	return engine.db.NewIterator(engine.readOptions)
+}
+
+func (engine *levigoEngine) put(key []byte, val []byte) error {
+	return engine.db.Put(engine.writeOptions, key, val)
+}
+
+func (engine *levigoEngine) write(entries []levelDBWriteEntry) error {
+	var wb = levigo.NewWriteBatch()
+	defer wb.Close()
+	for _, entry := range entries {
+		if entry.Delete {
+			wb.Delete(entry.Key)
+		} else {
+			wb.Put(entry.Key, entry.Val)
+		}
+	}
+	return engine.db.Write(engine.writeOptions, wb)
+}
+
+func (engine *levigoEngine) property(name string) string {
+	return engine.db.PropertyValue(name)
+}
+
+func (engine *levigoEngine) newSnapshot() ldbSnapshot {
+	var snapshot = engine.db.NewSnapshot()
+	var options = levigo.NewReadOptions()
+	options.SetSnapshot(snapshot)
+	options.SetVerifyChecksums(engine.verifyChecksums)
+	return &levigoSnapshot{
+		engine: engine,
+		snapshot: snapshot,
+		readOptions: options,
+	}
+}
+
+func (snapshot *levigoSnapshot) get(key []byte) ([]byte, error) {
+	return snapshot.engine.db.Get(snapshot.readOptions, key)
+}
+
+func (snapshot *levigoSnapshot) newIterator() ldbIterator {
+	return snapshot.engine.db.NewIterator(snapshot.readOptions)
+}
+
+func (snapshot *levigoSnapshot) release() {
+	snapshot.engine.db.ReleaseSnapshot(snapshot.snapshot)
+	snapshot.readOptions.Close()
+}
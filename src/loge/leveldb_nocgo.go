@@ -0,0 +1,12 @@
+//go:build !cgo
+// +build !cgo
+
+package loge
+
+import (
+	"errors"
+)
+
+func openLevigoEngine(basePath string, options LevelDBOptions) (ldbEngine, error) {
+	return nil, errors.New("levigo needs cgo; use NewGoLevelDBStore instead")
+}
@@ -7,7 +7,7 @@ import (
 type linkList []string
 type LinkSpec map[string]string
 
-type linkInfo struct {
+type LinkInfo struct {
 	Name string
 	Target string
 	Tag uint16
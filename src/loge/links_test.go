@@ -97,6 +97,7 @@ func TestLinkScoping(test *testing.T) {
 	if trans2.HasLink("test", "sibling", "one", "one") {
 		test.Errorf("Link scope leak: %v", trans2.ReadLinks("test", "sibling", "one"));
 	}
+	trans2.Cancel()
 
 	if !trans1.Commit() {
 		test.Error("Commit failed")
@@ -33,5 +33,5 @@ func (lock *spinLock) SpinLock() {
 }
 
 func (lock *spinLock) Unlock() {
-	lock.lock = lock_UNLOCKED
+	atomic.StoreInt32(&lock.lock, lock_UNLOCKED)
 }
@@ -0,0 +1,22 @@
+package loge
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Matches the leveled methods of *slog.Logger, so one can be passed
+// straight in. Args are alternating keys and values.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Warnings and errors only, to stderr
+func defaultLogger() Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelWarn,
+	}))
+}
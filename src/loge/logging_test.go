@@ -0,0 +1,34 @@
+package loge
+
+import (
+	"testing"
+	"bytes"
+	"strings"
+	"log/slog"
+)
+
+func TestLoggerOption(test *testing.T) {
+	var buf bytes.Buffer
+	var logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	var db = NewLogeDBWithOptions(NewMemStore(), DBOptions{ Logger: logger })
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var trans1 = db.CreateTransaction()
+	var trans2 = db.CreateTransaction()
+	trans1.Write("test", "one")
+	trans2.Write("test", "one")
+	trans1.Commit()
+	trans2.Commit()
+
+	var output = buf.String()
+	if !strings.Contains(output, "level=DEBUG msg=\"Transaction aborted\"") {
+		test.Errorf("Abort not logged: %s", output)
+	}
+	if !strings.Contains(output, "type=test key=one") {
+		test.Errorf("Abort missing object fields: %s", output)
+	}
+}
@@ -0,0 +1,448 @@
+package loge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const mem_DEFAULT_SNAPSHOT_INTERVAL = 10000
+const mem_SNAPSHOT_BATCH = 1000
+
+// Entry flags are the space, plus this if a value follows
+const mem_FLAG_VALUE byte = 0x80
+
+var errMemLogCorrupt = errors.New("corrupt record")
+
+// Logged with each commit, and kept out of the metadata space. The same
+// key LevelDB keeps it under.
+var memLastSnapshotKey = string(encodeTaggedKey([]uint16{ ldb_CHANGE_INFO_TAG }, "last"))
+
+type MemStoreOptions struct {
+	// Directory for the commit log and snapshots. Empty keeps
+	// everything in memory only.
+	Path string
+	// Commits logged between full snapshots, after which the log
+	// starts again
+	SnapshotInterval int
+	// DURABILITY_NONE leaves log writes to the OS; anything else fsyncs
+	// each commit.
+	Durability Durability
+	Logger Logger
+}
+
+func DefaultMemStoreOptions() MemStoreOptions {
+	return MemStoreOptions{
+		SnapshotInterval: mem_DEFAULT_SNAPSHOT_INTERVAL,
+	}
+}
+
+// Append-only log of commits since the last snapshot. Each record is a
+// length and CRC, then the commit's entries.
+type memLog struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+	writer *bufio.Writer
+	sync bool
+	records int
+	snapshotInterval int
+}
+
+// An in-memory store, recovered from (and logging commits to) a
+// directory on disk. Suits datasets small enough to hold in memory.
+func NewPersistentMemStore(path string) LogeStore {
+	var options = DefaultMemStoreOptions()
+	options.Path = path
+	return NewMemStoreWithOptions(options)
+}
+
+func NewMemStoreWithOptions(options MemStoreOptions) LogeStore {
+	if options.Logger == nil {
+		options.Logger = defaultLogger()
+	}
+
+	var store = newMemStore()
+	store.logger = options.Logger
+
+	if options.Path == "" {
+		return store
+	}
+
+	var log, err = openMemLog(store, options)
+	if err != nil {
+		panic(fmt.Sprintf("Can't open DB at %s: %v", options.Path, err))
+	}
+	store.log = log
+	loadTypeMetadata(store.spackTypes, store)
+
+	return store
+}
+
+func openMemLog(store *memStore, options MemStoreOptions) (*memLog, error) {
+	var err = os.MkdirAll(options.Path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	var log = &memLog{
+		path: options.Path,
+		sync: options.Durability != DURABILITY_NONE,
+		snapshotInterval: options.SnapshotInterval,
+	}
+	if log.snapshotInterval < 1 {
+		log.snapshotInterval = mem_DEFAULT_SNAPSHOT_INTERVAL
+	}
+
+	snapshot, err := os.Open(log.snapshotPath())
+	if err == nil {
+		_, err = readMemRecords(snapshot, store.replay)
+		snapshot.Close()
+		if err != nil {
+			return nil, fmt.Errorf("loading snapshot: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.file, err = os.OpenFile(log.logPath(), os.O_RDWR | os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything after the last whole record is a commit which never
+	// returned, so it's dropped
+	var good int64
+	good, err = readMemRecords(log.file, func(entries []memWriteEntry) {
+		store.replay(entries)
+		log.records++
+	})
+	if err == errMemLogCorrupt {
+		store.logger.Warn("Truncating commit log", "path", log.logPath(), "offset", good)
+		err = nil
+	}
+	if err == nil {
+		err = log.file.Truncate(good)
+	}
+	if err == nil {
+		_, err = log.file.Seek(good, io.SeekStart)
+	}
+	if err != nil {
+		log.file.Close()
+		return nil, err
+	}
+
+	log.writer = bufio.NewWriter(log.file)
+	return log, nil
+}
+
+func (log *memLog) snapshotPath() string {
+	return filepath.Join(log.path, "snapshot")
+}
+
+func (log *memLog) logPath() string {
+	return filepath.Join(log.path, "log")
+}
+
+func (log *memLog) append(entries []memWriteEntry) error {
+	var err = writeMemRecord(log.writer, entries)
+	if err == nil {
+		err = log.writer.Flush()
+	}
+	if err == nil && log.sync {
+		err = log.file.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	log.records++
+	return nil
+}
+
+func (log *memLog) close() error {
+	var err = log.writer.Flush()
+	if closeErr := log.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+
+// -----------------------------------------------
+// Store hooks
+// -----------------------------------------------
+
+// Commits go to the log before memory, under the log lock, so a
+// snapshot never misses a logged commit.
+func (store *memStore) logCommit(writes []memWriteEntry, sID uint64) error {
+	var log = store.log
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	var logged = writes
+	if sID > 0 {
+		logged = append(writes[:len(writes):len(writes)], lastSnapshotEntry(sID))
+	}
+
+	var err = log.append(logged)
+	if err != nil {
+		return err
+	}
+
+	store.apply(writes, sID)
+
+	if log.records >= log.snapshotInterval {
+		err = store.writeSnapshot()
+		if err != nil {
+			store.logger.Error("Snapshot failed", "path", log.snapshotPath(), "error", err)
+		}
+	}
+	return nil
+}
+
+// Writes the latest version of everything, then empties the log.
+// Called with the log lock held.
+func (store *memStore) writeSnapshot() error {
+	var log = store.log
+	var entries = store.latestEntries()
+
+	var tmpPath = log.snapshotPath() + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	var writer = bufio.NewWriter(file)
+	for start := 0; start < len(entries) && err == nil; start += mem_SNAPSHOT_BATCH {
+		var end = start + mem_SNAPSHOT_BATCH
+		if end > len(entries) {
+			end = len(entries)
+		}
+		err = writeMemRecord(writer, entries[start:end])
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, log.snapshotPath())
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// A crash before this just replays commits the snapshot already has
+	err = log.writer.Flush()
+	if err == nil {
+		err = log.file.Truncate(0)
+	}
+	if err == nil {
+		_, err = log.file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.writer.Reset(log.file)
+	store.logger.Debug("Wrote snapshot", "path", log.snapshotPath(), "entries", len(entries), "commits", log.records)
+	log.records = 0
+	return nil
+}
+
+func (store *memStore) latestEntries() []memWriteEntry {
+	store.lock.SpinLock()
+	defer store.lock.Unlock()
+
+	var entries = make([]memWriteEntry, 0, len(store.objects) + len(store.indexes) + 1)
+	for space := memSpace(0); space < mem_NUM_SPACES; space++ {
+		for key, mvh := range store.space(space) {
+			var blob = mvh[len(mvh) - 1].blob
+			if blob != nil {
+				entries = append(entries, memWriteEntry{ key, blob, space })
+			}
+		}
+	}
+	if store.lastSnapshotID > 0 {
+		entries = append(entries, lastSnapshotEntry(store.lastSnapshotID))
+	}
+
+	sort.Sort(memEntriesByKey(entries))
+	return entries
+}
+
+// Recovered state predates every snapshot the DB will open
+func (store *memStore) replay(entries []memWriteEntry) {
+	for _, entry := range entries {
+		if entry.Space == mem_METADATA && entry.CacheKey == memLastSnapshotKey {
+			if len(entry.Value) == 8 {
+				var sID = binary.BigEndian.Uint64(entry.Value)
+				if sID > store.lastSnapshotID {
+					store.lastSnapshotID = sID
+				}
+			}
+			continue
+		}
+
+		var objects = store.space(entry.Space)
+		if entry.Value == nil {
+			delete(objects, entry.CacheKey)
+		} else {
+			objects[entry.CacheKey] = memVersionHistory{ memVersion{ 0, entry.Value } }
+		}
+	}
+}
+
+func lastSnapshotEntry(sID uint64) memWriteEntry {
+	var val = make([]byte, 8)
+	binary.BigEndian.PutUint64(val, sID)
+	return memWriteEntry{ memLastSnapshotKey, val, mem_METADATA }
+}
+
+type memEntriesByKey []memWriteEntry
+
+func (es memEntriesByKey) Len() int { return len(es) }
+func (es memEntriesByKey) Less(i, j int) bool { return es[i].CacheKey < es[j].CacheKey }
+func (es memEntriesByKey) Swap(i, j int) { es[i], es[j] = es[j], es[i] }
+
+
+// -----------------------------------------------
+// Record encoding
+// -----------------------------------------------
+
+func writeMemRecord(w io.Writer, entries []memWriteEntry) error {
+	var payload = make([]byte, 0, 64)
+	payload = binary.AppendUvarint(payload, uint64(len(entries)))
+	for _, entry := range entries {
+		var flags = byte(entry.Space)
+		if entry.Value != nil {
+			flags |= mem_FLAG_VALUE
+		}
+		payload = append(payload, flags)
+		payload = binary.AppendUvarint(payload, uint64(len(entry.CacheKey)))
+		payload = append(payload, entry.CacheKey...)
+		if entry.Value != nil {
+			payload = binary.AppendUvarint(payload, uint64(len(entry.Value)))
+			payload = append(payload, entry.Value...)
+		}
+	}
+
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Calls apply for each whole record, and returns the offset after the
+// last one.
+func readMemRecords(r io.Reader, apply func([]memWriteEntry)) (int64, error) {
+	var reader = bufio.NewReader(r)
+	var offset int64
+	var header [8]byte
+
+	for {
+		var _, err = io.ReadFull(reader, header[:])
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, errMemLogCorrupt
+		}
+
+		var payload = make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		_, err = io.ReadFull(reader, payload)
+		if err != nil || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, errMemLogCorrupt
+		}
+
+		entries, err := decodeMemRecord(payload)
+		if err != nil {
+			return offset, err
+		}
+
+		apply(entries)
+		offset += int64(len(header) + len(payload))
+	}
+}
+
+func decodeMemRecord(payload []byte) ([]memWriteEntry, error) {
+	var next = func() (uint64, bool) {
+		var value, n = binary.Uvarint(payload)
+		if n <= 0 {
+			return 0, false
+		}
+		payload = payload[n:]
+		return value, true
+	}
+	var take = func(length uint64) ([]byte, bool) {
+		if uint64(len(payload)) < length {
+			return nil, false
+		}
+		var bytes = payload[:length:length]
+		payload = payload[length:]
+		return bytes, true
+	}
+
+	var count, ok = next()
+	if !ok {
+		return nil, errMemLogCorrupt
+	}
+
+	var entries = make([]memWriteEntry, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if len(payload) == 0 {
+			return nil, errMemLogCorrupt
+		}
+		var flags = payload[0]
+		payload = payload[1:]
+
+		var space = memSpace(flags &^ mem_FLAG_VALUE)
+		if space >= mem_NUM_SPACES {
+			return nil, errMemLogCorrupt
+		}
+
+		var length, ok = next()
+		var key []byte
+		if ok {
+			key, ok = take(length)
+		}
+		if !ok {
+			return nil, errMemLogCorrupt
+		}
+
+		var entry = memWriteEntry{
+			CacheKey: string(key),
+			Space: space,
+		}
+
+		if flags & mem_FLAG_VALUE != 0 {
+			length, ok = next()
+			if ok {
+				entry.Value, ok = take(length)
+			}
+			if !ok {
+				return nil, errMemLogCorrupt
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
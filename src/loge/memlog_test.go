@@ -0,0 +1,103 @@
+package loge
+
+import (
+	"testing"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+func TestPersistentMemStore(test *testing.T) {
+	var dir = test.TempDir()
+	var db = openStoreTest(NewPersistentMemStore(dir))
+
+	db.Transact(func (t *Transaction) {
+		t.Set("test", "one", &TestObj{ "One" })
+		t.Set("test", "two", &TestObj{ "Two" })
+		t.AddLink("test", "other", "one", "two")
+	}, 0)
+	db.SetOne("test", "one", &TestObj{ "Updated" })
+	db.DeleteOne("test", "two")
+	db.Close()
+
+	db = openStoreTest(NewPersistentMemStore(dir))
+	defer db.Close()
+
+	if db.ReadOne("test", "one").(*TestObj).Name != "Updated" {
+		test.Error("Update lost on reopen")
+	}
+	if db.ExistsOne("test", "two") {
+		test.Error("Delete lost on reopen")
+	}
+	if !reflect.DeepEqual(db.ReadLinksOne("test", "other", "one"), []string{ "two" }) {
+		test.Error("Links lost on reopen")
+	}
+	if !reflect.DeepEqual(db.Find("test", "other", "two"), []LogeKey{ "one" }) {
+		test.Error("Index lost on reopen")
+	}
+}
+
+func TestMemStoreLogRecovery(test *testing.T) {
+	var dir = test.TempDir()
+	var options = DefaultMemStoreOptions()
+	options.Path = dir
+
+	// Never closed, so recovery has only the log
+	var db = openStoreTest(NewMemStoreWithOptions(options))
+	db.SetOne("test", "one", &TestObj{ "One" })
+	db.SetOne("test", "two", &TestObj{ "Two" })
+	var last = db.store.(SnapshotStore).LastSnapshotID()
+
+	// A commit torn part way through writing
+	var logFile, err = os.OpenFile(filepath.Join(dir, "log"), os.O_APPEND | os.O_WRONLY, 0644)
+	if err != nil {
+		test.Fatalf("Can't open log: %v", err)
+	}
+	logFile.Write([]byte{ 0, 0, 0, 40, 1, 2, 3 })
+	logFile.Close()
+
+	db = openStoreTest(NewMemStoreWithOptions(options))
+
+	if db.ReadOne("test", "two").(*TestObj).Name != "Two" {
+		test.Error("Logged commit lost")
+	}
+	if recovered := db.store.(SnapshotStore).LastSnapshotID(); recovered != last {
+		test.Errorf("Recovered last snapshot %d, was %d", recovered, last)
+	}
+
+	db.SetOne("test", "three", &TestObj{ "Three" })
+	db.Close()
+
+	db = openStoreTest(NewMemStoreWithOptions(options))
+	defer db.Close()
+
+	if !db.ExistsOne("test", "one") || !db.ExistsOne("test", "three") {
+		test.Error("Commit lost after truncated log")
+	}
+}
+
+func TestMemStoreSnapshots(test *testing.T) {
+	var dir = test.TempDir()
+	var options = DefaultMemStoreOptions()
+	options.Path = dir
+	options.SnapshotInterval = 3
+
+	var db = openStoreTest(NewMemStoreWithOptions(options))
+	for i := 0; i < 10; i++ {
+		db.SetOne("test", LogeKey(string(rune('a' + i))), &TestObj{ "Value" })
+	}
+
+	if records := db.store.Stats()["loge_memstore_log_records"]; records >= 3 {
+		test.Errorf("Log not restarted after snapshot: %v records", records)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshot")); err != nil {
+		test.Errorf("No snapshot written: %v", err)
+	}
+
+	db = openStoreTest(NewMemStoreWithOptions(options))
+	defer db.Close()
+
+	if keys := db.ListSlice("test", "", -1); len(keys) != 10 {
+		test.Errorf("Wrong keys after reopen: %v", keys)
+	}
+}
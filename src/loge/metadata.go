@@ -0,0 +1,111 @@
+package loge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/brendonh/spack"
+)
+
+// Reserved key tags, below any type's. Every store lays out its keys the
+// same way, so encoded objects can move between backends.
+const ldb_LINK_TAG uint16 = 2
+const ldb_LINK_INFO_TAG uint16 = 3
+const ldb_INDEX_TAG uint16 = 4
+const ldb_CHANGE_TAG uint16 = 5
+const ldb_CHANGE_INFO_TAG uint16 = 6
+const ldb_HISTORY_TAG uint16 = 7
+const ldb_START_TAG uint16 = 8
+
+// Where a store keeps type and link metadata
+type metadataKV interface {
+	iterateMetadata(prefix []byte, each func(val []byte))
+	putMetadata(key []byte, val []byte) error
+}
+
+func newMetadataTypeSet() *spack.TypeSet {
+	var types = spack.NewTypeSet()
+	types.LastTag = ldb_START_TAG
+	return types
+}
+
+func loadTypeMetadata(types *spack.TypeSet, kv metadataKV) {
+	var typeType = types.Type("_type")
+	kv.iterateMetadata(typeType.EncodeTag(), func(val []byte) {
+		var typeInfo, _, err = typeType.DecodeObj(val, false)
+
+		if err != nil {
+			panic(fmt.Sprintf("Error loading type info: %v", err))
+		}
+
+		types.LoadType(typeInfo.(*spack.VersionedType))
+	})
+}
+
+func registerTypeMetadata(types *spack.TypeSet, kv metadataKV, typ *LogeType, logger Logger) {
+	tagLinks(kv, typ, logger)
+
+	var vt = typ.SpackType
+
+	if (!vt.Dirty) {
+		return
+	}
+
+	logger.Info("Updating type info", "type", typ.Name, "version", typ.Version)
+
+	var typeType = types.Type("_type")
+	var keyVal = typeType.EncodeKey(vt.Name)
+	var typeVal, err = typeType.EncodeObj(vt)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error encoding type %s: %v", vt.Name, err))
+	}
+
+	err = kv.putMetadata(keyVal, typeVal)
+
+	if err != nil {
+		panic(fmt.Sprintf("Couldn't write type metadata: %v\n", err))
+	}
+
+	vt.Dirty = false
+}
+
+// Loads stored link tags, and assigns new links the next ones free, in
+// name order.
+func tagLinks(kv metadataKV, typ *LogeType, logger Logger) {
+	var vt = typ.SpackType
+	var prefix = encodeTaggedKey([]uint16{ldb_LINK_INFO_TAG, vt.Tag}, "")
+
+	kv.iterateMetadata(prefix, func(val []byte) {
+		var info = &LinkInfo{}
+		spack.DecodeFromBytes(info, linkInfoSpec, val)
+		typ.Links[info.Name] = info
+	})
+
+	var maxTag uint16 = 0;
+	var missing = make([]string, 0)
+
+	for name, info := range typ.Links {
+		if info.Tag > maxTag {
+			maxTag = info.Tag
+		}
+		if info.Tag == 0 {
+			missing = append(missing, name)
+		}
+	}
+
+	sort.Strings(missing)
+
+	for _, name := range missing {
+		var info = typ.Links[name]
+		maxTag++
+		info.Tag = maxTag
+		var key = encodeTaggedKey([]uint16{ldb_LINK_INFO_TAG, vt.Tag}, info.Name)
+		enc, _ := spack.EncodeToBytes(info, linkInfoSpec)
+		logger.Info("Updating link", "type", typ.Name, "link", info.Name, "tag", info.Tag)
+		var err = kv.putMetadata(key, enc)
+		if err != nil {
+			panic(fmt.Sprintf("Write error: %v\n", err))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package loge
+
+import (
+	"testing"
+)
+
+func TestLinkTags(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Links = LinkSpec{ "c": "test", "a": "test", "b": "test" }
+	var typ = db.CreateType(def)
+
+	for tag, name := range []string{ "a", "b", "c" } {
+		if typ.Links[name].Tag != uint16(tag + 1) {
+			test.Errorf("Wrong tag for %s: %d", name, typ.Links[name].Tag)
+		}
+	}
+}
+
+func TestKeysMatchAcrossBackends(test *testing.T) {
+	var memDir = test.TempDir()
+	var stores = []LogeStore{
+		NewPersistentMemStore(memDir),
+		NewGoLevelDBStore(test.TempDir()),
+	}
+
+	var keys []string
+	for _, store := range stores {
+		var db = createKeyTestTypes(store, false)
+		keys = append(keys, keyTestRefs(db)...)
+		db.Close()
+	}
+
+	// And after reopening with types created the other way round
+	var db = createKeyTestTypes(NewPersistentMemStore(memDir), true)
+	defer db.Close()
+	keys = append(keys, keyTestRefs(db)...)
+
+	for i := 3; i < len(keys); i++ {
+		if keys[i] != keys[i % 3] {
+			test.Errorf("Key %d differs: %q vs %q", i, keys[i], keys[i % 3])
+		}
+	}
+}
+
+func createKeyTestTypes(store LogeStore, reversed bool) *LogeDB {
+	var db = NewLogeDB(store)
+	var first = NewTypeDef("first", 1, &TestObj{})
+	first.Links = LinkSpec{ "x": "second", "y": "second" }
+	var second = NewTypeDef("second", 1, &TestObj{})
+
+	if reversed {
+		db.CreateType(second)
+		db.CreateType(first)
+	} else {
+		db.CreateType(first)
+		db.CreateType(second)
+	}
+	return db
+}
+
+func keyTestRefs(db *LogeDB) []string {
+	return []string{
+		db.makeObjRef("second", "key").CacheKey,
+		db.makeLinkRef("first", "x", "key").CacheKey,
+		db.makeLinkRef("first", "y", "key").CacheKey,
+	}
+}
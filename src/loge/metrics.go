@@ -0,0 +1,299 @@
+package loge
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	metric_COMMITS = "loge_commits_total"
+	metric_ABORTS = "loge_aborts_total"
+	metric_RETRIES = "loge_retries_total"
+	metric_COMMIT_ERRORS = "loge_commit_errors_total"
+	metric_VALIDATION_FAILURES = "loge_validation_failures_total"
+	metric_EXPIRED = "loge_expired_objects_total"
+	metric_COMMIT_SECONDS = "loge_commit_seconds"
+	metric_LOCK_WAIT_SECONDS = "loge_commit_lock_wait_seconds"
+	metric_CACHE_HITS = "loge_cache_hits_total"
+	metric_CACHE_MISSES = "loge_cache_misses_total"
+	metric_CACHE_EVICTIONS = "loge_cache_evictions_total"
+	metric_CACHE_OBJECTS = "loge_cache_objects"
+	metric_CACHE_RETAINED = "loge_cache_retained_objects"
+	metric_OPEN_SNAPSHOTS = "loge_open_snapshots"
+	metric_OLDEST_SNAPSHOT_SECONDS = "loge_oldest_snapshot_age_seconds"
+)
+
+var defaultBuckets = []float64{
+	0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5,
+}
+
+// Names may carry Prometheus labels, as name{label="value"}
+type Metrics interface {
+	Count(name string, delta uint64)
+	Gauge(name string, value float64)
+	Observe(name string, value float64)
+}
+
+
+// -----------------------------------------------
+// In-memory implementation
+// -----------------------------------------------
+
+type MemMetrics struct {
+	lock sync.RWMutex
+	counters map[string]*uint64
+	gauges map[string]float64
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	lock spinLock
+	buckets []float64
+	counts []uint64
+	count uint64
+	sum float64
+}
+
+type MetricsSnapshot struct {
+	Counters map[string]uint64
+	Gauges map[string]float64
+	Histograms map[string]HistogramSnapshot
+}
+
+type HistogramSnapshot struct {
+	Buckets []float64
+	Counts []uint64
+	Count uint64
+	Sum float64
+}
+
+func NewMemMetrics() *MemMetrics {
+	return &MemMetrics{
+		counters: make(map[string]*uint64),
+		gauges: make(map[string]float64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (m *MemMetrics) Count(name string, delta uint64) {
+	m.lock.RLock()
+	var counter, ok = m.counters[name]
+	m.lock.RUnlock()
+
+	if !ok {
+		m.lock.Lock()
+		counter, ok = m.counters[name]
+		if !ok {
+			counter = new(uint64)
+			m.counters[name] = counter
+		}
+		m.lock.Unlock()
+	}
+
+	atomic.AddUint64(counter, delta)
+}
+
+func (m *MemMetrics) Gauge(name string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.gauges[name] = value
+}
+
+func (m *MemMetrics) Observe(name string, value float64) {
+	m.lock.RLock()
+	var hist, ok = m.histograms[name]
+	m.lock.RUnlock()
+
+	if !ok {
+		m.lock.Lock()
+		hist, ok = m.histograms[name]
+		if !ok {
+			hist = &histogram{
+				buckets: defaultBuckets,
+				counts: make([]uint64, len(defaultBuckets)),
+			}
+			m.histograms[name] = hist
+		}
+		m.lock.Unlock()
+	}
+
+	hist.observe(value)
+}
+
+func (m *MemMetrics) Snapshot() MetricsSnapshot {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var snapshot = MetricsSnapshot{
+		Counters: make(map[string]uint64),
+		Gauges: make(map[string]float64),
+		Histograms: make(map[string]HistogramSnapshot),
+	}
+
+	for name, counter := range m.counters {
+		snapshot.Counters[name] = atomic.LoadUint64(counter)
+	}
+	for name, value := range m.gauges {
+		snapshot.Gauges[name] = value
+	}
+	for name, hist := range m.histograms {
+		snapshot.Histograms[name] = hist.snapshot()
+	}
+
+	return snapshot
+}
+
+func (hist *histogram) observe(value float64) {
+	hist.lock.SpinLock()
+	defer hist.lock.Unlock()
+
+	for i, bound := range hist.buckets {
+		if value <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (hist *histogram) snapshot() HistogramSnapshot {
+	hist.lock.SpinLock()
+	defer hist.lock.Unlock()
+
+	var counts = make([]uint64, len(hist.counts))
+	copy(counts, hist.counts)
+
+	return HistogramSnapshot{
+		Buckets: hist.buckets,
+		Counts: counts,
+		Count: hist.count,
+		Sum: hist.sum,
+	}
+}
+
+
+// -----------------------------------------------
+// Prometheus text exporter
+// -----------------------------------------------
+
+func (snapshot MetricsSnapshot) WritePrometheus(w io.Writer) error {
+	var typed = make(map[string]bool)
+	var writeType = func(name string, metricType string) {
+		var family = metricFamily(name)
+		if !typed[family] {
+			typed[family] = true
+			fmt.Fprintf(w, "# TYPE %s %s\n", family, metricType)
+		}
+	}
+
+	for _, name := range sortedKeys(snapshot.Counters) {
+		writeType(name, "counter")
+		_, err := fmt.Fprintf(w, "%s %d\n", name, snapshot.Counters[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedKeys(snapshot.Gauges) {
+		writeType(name, "gauge")
+		_, err := fmt.Fprintf(w, "%s %g\n", name, snapshot.Gauges[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedKeys(snapshot.Histograms) {
+		var hist = snapshot.Histograms[name]
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		for i, bound := range hist.Buckets {
+			fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, hist.Counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, hist.Count)
+		fmt.Fprintf(w, "%s_sum %g\n", name, hist.Sum)
+		_, err := fmt.Fprintf(w, "%s_count %d\n", name, hist.Count)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Serves the DB's metrics in Prometheus text format. Only works with
+// the default in-memory implementation; anything else is expected to
+// export itself.
+func (db *LogeDB) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mem, ok = db.metrics.(*MemMetrics)
+		if !ok {
+			http.Error(w, "Metrics not held in memory", http.StatusNotFound)
+			return
+		}
+
+		db.UpdateGauges()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		mem.Snapshot().WritePrometheus(w)
+	})
+}
+
+
+// -----------------------------------------------
+// LogeDB hooks
+// -----------------------------------------------
+
+// Setup only: the DB reads its metrics unsynchronized, so this mustn't
+// race with transactions. DBOptions.Metrics is the safer way.
+func (db *LogeDB) SetMetrics(metrics Metrics) {
+	db.metrics = metrics
+}
+
+func (db *LogeDB) Metrics() Metrics {
+	return db.metrics
+}
+
+// Gauges are sampled rather than tracked, so exporters call this
+// before reading them.
+func (db *LogeDB) UpdateGauges() {
+	var cacheStats = db.CacheStats()
+	db.metrics.Gauge(metric_CACHE_OBJECTS, float64(cacheStats.Size))
+	db.metrics.Gauge(metric_CACHE_RETAINED, float64(cacheStats.Retained))
+	db.metrics.Gauge(metric_OPEN_SNAPSHOTS, float64(len(db.activeSnapshots())))
+	db.metrics.Gauge(metric_OLDEST_SNAPSHOT_SECONDS, db.OldestSnapshotAge().Seconds())
+
+	for name, value := range db.store.Stats() {
+		db.metrics.Gauge(name, value)
+	}
+}
+
+// The name without its labels
+func metricFamily(name string) string {
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]float64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]HistogramSnapshot:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package loge
+
+import (
+	"testing"
+	"strings"
+	"net/http/httptest"
+)
+
+func TestCommitMetrics(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var trans1 = db.CreateTransaction()
+	var trans2 = db.CreateTransaction()
+	trans1.Set("test", "one", &TestObj{Name: "Two"})
+	trans2.Set("test", "one", &TestObj{Name: "Three"})
+	trans1.Commit()
+	trans2.Commit()
+
+	var snapshot = db.Metrics().(*MemMetrics).Snapshot()
+
+	if snapshot.Counters[metric_COMMITS] != 2 {
+		test.Errorf("Wrong commit count: %d", snapshot.Counters[metric_COMMITS])
+	}
+	if snapshot.Counters[metric_ABORTS] != 1 {
+		test.Errorf("Wrong abort count: %d", snapshot.Counters[metric_ABORTS])
+	}
+	if snapshot.Histograms[metric_COMMIT_SECONDS].Count != 3 {
+		test.Errorf("Wrong commit latency count: %d",
+			snapshot.Histograms[metric_COMMIT_SECONDS].Count)
+	}
+}
+
+func TestHistogramBuckets(test *testing.T) {
+	var metrics = NewMemMetrics()
+	metrics.Observe("latency", 0.002)
+	metrics.Observe("latency", 0.2)
+	metrics.Observe("latency", 50)
+
+	var hist = metrics.Snapshot().Histograms["latency"]
+	if hist.Count != 3 {
+		test.Errorf("Wrong histogram count: %d", hist.Count)
+	}
+	for i, bound := range hist.Buckets {
+		var expected uint64
+		if bound >= 0.002 {
+			expected++
+		}
+		if bound >= 0.2 {
+			expected++
+		}
+		if hist.Counts[i] != expected {
+			test.Errorf("Wrong count for bucket %g: %d", bound, hist.Counts[i])
+		}
+	}
+}
+
+func TestPrometheusExport(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var recorder = httptest.NewRecorder()
+	db.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	var body = recorder.Body.String()
+
+	for _, expected := range []string{
+		"# TYPE loge_commits_total counter\nloge_commits_total 1\n",
+		"# TYPE loge_cache_objects gauge\n",
+		"loge_commit_seconds_bucket{le=\"+Inf\"} 1\n",
+		"loge_memstore_keys 1\n",
+	} {
+		if !strings.Contains(body, expected) {
+			test.Errorf("Missing from export: %q\n%s", expected, body)
+		}
+	}
+}
+
+func TestPrometheusLabels(test *testing.T) {
+	var metrics = NewMemMetrics()
+	metrics.Gauge(`loge_leveldb_files{level="0"}`, 2)
+	metrics.Gauge(`loge_leveldb_files{level="1"}`, 3)
+
+	var out strings.Builder
+	metrics.Snapshot().WritePrometheus(&out)
+
+	var expected = "# TYPE loge_leveldb_files gauge\n" +
+		"loge_leveldb_files{level=\"0\"} 2\nloge_leveldb_files{level=\"1\"} 3\n"
+	if out.String() != expected {
+		test.Errorf("Wrong labelled export: %q", out.String())
+	}
+}
@@ -3,18 +3,24 @@ package loge
 import (
 	"fmt"
 	"reflect"
+	"sync"
 
 	"github.com/brendonh/spack"
 )
 
+// The shard lock covers the version chain, refcount and invalid flag.
+// Lock is only held by commits.
 type logeObject struct {
 	DB *LogeDB
-	Type *logeType
+	Type *LogeType
 	Key LogeKey
 	Current *objectVersion
 	RefCount uint32
 	LinkName string
-	Lock spinLock
+	Lock sync.Mutex
+	cachedAt uint64
+	invalid bool
+	shard *cacheShard
 }
 
 type objectVersion struct {
@@ -26,7 +32,7 @@ type objectVersion struct {
 }
 
 
-func initializeObject(db *LogeDB, t *logeType, key LogeKey) *logeObject {
+func initializeObject(db *LogeDB, t *LogeType, key LogeKey) *logeObject {
 	return &logeObject{
 		DB: db,
 		Type: t,
@@ -36,7 +42,7 @@ func initializeObject(db *LogeDB, t *logeType, key LogeKey) *logeObject {
 	}
 }
 
-func (obj *logeObject) makeObjRef() objRef {
+func (obj *logeObject) makeObjRef() ObjRef {
 	if obj.LinkName != "" {
 		return makeLinkRef(obj.Type, obj.LinkName, obj.Key)
 	}
@@ -44,41 +50,39 @@ func (obj *logeObject) makeObjRef() objRef {
 }
 
 func (obj *logeObject) ensureVersion(sID uint64) *objectVersion {
+	var next *objectVersion
 	var current = obj.Current
 
-	if current == nil {
-		current = &objectVersion{
-			LogeObj: obj,
-			snapshotID: sID,
-		}
-		obj.Current = current
-		return current
-	}
-
-	var next = current
 	for current != nil && current.snapshotID > sID {
 		next = current
 		current = current.Previous
 	}
 
-	if current != nil && current.snapshotID == sID {
+	// Every commit since the object was cached is in the chain, so
+	// versions from then on stay valid for later snapshots too.
+	if current != nil && (current.snapshotID == sID || current.snapshotID >= obj.cachedAt) {
 		return current
 	}
 
 	var newVersion = &objectVersion{
 		LogeObj: obj,
 		snapshotID: sID,
+		Previous: current,
 	}
 
-	newVersion.Previous = current
-	next.Previous = newVersion
+	if next == nil {
+		obj.Current = newVersion
+	} else {
+		next.Previous = newVersion
+	}
 	
 	return newVersion
 }
 
-func (obj *logeObject) applyVersion(object interface{}, context transactionContext, sID uint64) {
+func (obj *logeObject) applyVersion(object interface{}, context TransactionContext, sID uint64, active SnapshotIDs) []byte {
 	var blob = obj.encode(object)
 
+	obj.shard.lock.Lock()
 	obj.Current = &objectVersion{
 		LogeObj: obj,
 		Blob: blob,
@@ -86,20 +90,47 @@ func (obj *logeObject) applyVersion(object interface{}, context transactionConte
 		snapshotID: sID,
 		loaded: true,
 	}
+	obj.pruneVersions(active)
+	obj.shard.lock.Unlock()
 
 	var ref = obj.makeObjRef()
-	context.store(ref, blob)
+	context.Store(ref, blob)
 
 	if obj.LinkName != "" {
 		var links = object.(*linkSet)
 		
 		for _, target := range links.Removed {
-			context.remIndex(makeLinkRef(obj.Type, obj.LinkName, LogeKey(target)), obj.Key)
+			context.RemoveIndex(makeLinkRef(obj.Type, obj.LinkName, LogeKey(target)), obj.Key)
 		}
 		for _, target := range links.Added {
-			context.addIndex(makeLinkRef(obj.Type, obj.LinkName, LogeKey(target)), obj.Key)
+			context.AddIndex(makeLinkRef(obj.Type, obj.LinkName, LogeKey(target)), obj.Key)
 		}
 	}
+
+	return blob
+}
+
+// The loaded version a reader at sID would see, if the chain can say.
+// Same rules as ensureVersion, without creating anything.
+func (obj *logeObject) visibleVersion(sID uint64) *objectVersion {
+	var current = obj.Current
+	for current != nil && current.snapshotID > sID {
+		current = current.Previous
+	}
+
+	if current == nil || !current.loaded || obj.invalid {
+		return nil
+	}
+	if current.snapshotID == sID || current.snapshotID >= obj.cachedAt {
+		return current
+	}
+	return nil
+}
+
+func (obj *logeObject) currentSnapshotID() uint64 {
+	obj.shard.lock.Lock()
+	defer obj.shard.lock.Unlock()
+	return obj.Current.snapshotID
 }
 
 func (obj *logeObject) decode(blob []byte, toJSON bool) (object interface{}, upgraded bool) {
@@ -134,8 +165,3 @@ func (obj *logeObject) encode(object interface{}) []byte {
 func (obj *logeObject) hasValue(object interface{}) bool {
 	return !reflect.ValueOf(object).IsNil()
 }
-
-
-func (version *objectVersion) getObject(toJSON bool) (interface{}, bool) {
-	return version.LogeObj.decode(version.Blob, toJSON)
-}
\ No newline at end of file
@@ -5,18 +5,20 @@ import (
 	"encoding/binary"
 )
 
-type objRef struct {
-	Type *logeType
+// An object, or one of its link sets. CacheKey is the encoded type
+// (and link) tag plus key, and is what stores address things by.
+type ObjRef struct {
+	Type *LogeType
 	Key LogeKey
 	LinkName string
 	CacheKey string
 }
 
-func encodeTypeTag(typ *logeType) uint32 {
+func encodeTypeTag(typ *LogeType) uint32 {
 	return uint32(typ.SpackType.Tag) << 16
 }
 
-func typePrefix(typ *logeType) []byte {
+func typePrefix(typ *LogeType) []byte {
 	var buf = bytes.NewBuffer(make([]byte, 0, 4))
 	binary.Write(buf, binary.BigEndian, encodeTypeTag(typ))
 	return buf.Bytes()
@@ -30,24 +32,24 @@ func encodeKey(tag uint32, key LogeKey) string {
 	return string(buf.Bytes())
 }
 
-func makeObjRef(typ *logeType, key LogeKey) objRef {
+func makeObjRef(typ *LogeType, key LogeKey) ObjRef {
 	var tag = encodeTypeTag(typ)
 	var cacheKey = encodeKey(tag, key)
-	var ref = objRef{ typ, key, "", cacheKey }
+	var ref = ObjRef{ typ, key, "", cacheKey }
 	return ref
 }
 
-func makeLinkRef(typ *logeType, linkName string, key LogeKey) objRef {
+func makeLinkRef(typ *LogeType, linkName string, key LogeKey) ObjRef {
 	var tag = encodeTypeTag(typ) | uint32(typ.Links[linkName].Tag)
 	var cacheKey = encodeKey(tag, key)
-	var ref = objRef{ typ, key, linkName, cacheKey }
+	var ref = ObjRef{ typ, key, linkName, cacheKey }
 	return ref
 }
 
-func (objRef objRef) String() string {
-	return objRef.CacheKey
+func (ref ObjRef) String() string {
+	return ref.CacheKey
 }
 
-func (objRef objRef) IsLink() bool {
-	return objRef.LinkName != ""
+func (ref ObjRef) IsLink() bool {
+	return ref.LinkName != ""
 }
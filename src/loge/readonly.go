@@ -0,0 +1,150 @@
+package loge
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/brendonh/spack"
+)
+
+// Reads from a fixed snapshot, without locking or holding any objects,
+// so it never aborts or gets in the way of writers. The snapshot is the
+// latest one whose commits have all finished.
+type ReadTransaction struct {
+	db *LogeDB
+	context TransactionContext
+	snapshotID uint64
+	giveJSON bool
+	closed bool
+}
+
+type ReadTransactor func(*ReadTransaction)
+
+// Holds its snapshot, as CreateTransaction, until Close
+func (db *LogeDB) CreateReadTransaction() *ReadTransaction {
+	var sID = db.beginSnapshot()
+	return &ReadTransaction{
+		db: db,
+		context: db.store.NewContext(sID),
+		snapshotID: sID,
+	}
+}
+
+func (db *LogeDB) View(actor ReadTransactor) {
+	db.doView(actor, false)
+}
+
+func (db *LogeDB) ViewJSON(actor ReadTransactor) {
+	db.doView(actor, true)
+}
+
+func (db *LogeDB) doView(actor ReadTransactor, giveJSON bool) {
+	var t = db.CreateReadTransaction()
+	t.giveJSON = giveJSON
+	defer t.Close()
+	actor(t)
+}
+
+func (t *ReadTransaction) String() string {
+	return fmt.Sprintf("ReadTransaction<%d>", t.snapshotID)
+}
+
+func (t *ReadTransaction) SnapshotID() uint64 {
+	return t.snapshotID
+}
+
+func (t *ReadTransaction) Close() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.context.Rollback()
+	t.db.endSnapshot(t.snapshotID)
+}
+
+func (t *ReadTransaction) Exists(typeName string, key LogeKey) bool {
+	var ref = t.db.makeObjRef(typeName, key)
+	return len(t.readBlob(ref)) > 0 && !t.expired(ref)
+}
+
+func (t *ReadTransaction) Read(typeName string, key LogeKey) interface{} {
+	var ref = t.db.makeObjRef(typeName, key)
+	var blob = t.readBlob(ref)
+	if len(blob) > 0 && t.expired(ref) {
+		blob = nil
+	}
+	var object, _ = ref.Type.Decode(blob, t.giveJSON)
+	return object
+}
+
+func (t *ReadTransaction) ReadLinks(typeName string, linkName string, key LogeKey) []string {
+	return t.readLinks(t.db.makeLinkRef(typeName, linkName, key))
+}
+
+func (t *ReadTransaction) HasLink(typeName string, linkName string, key LogeKey, target LogeKey) bool {
+	return linkList(t.readLinks(t.db.makeLinkRef(typeName, linkName, key))).Has(string(target))
+}
+
+func (t *ReadTransaction) Find(typeName string, linkName string, target LogeKey) ResultSet {
+	t.checkOpen()
+	return t.context.Find(t.db.makeLinkRef(typeName, linkName, target))
+}
+
+func (t *ReadTransaction) FindSlice(typeName string, linkName string, target LogeKey, from LogeKey, limit int) ResultSet {
+	t.checkOpen()
+	return t.context.FindSlice(t.db.makeLinkRef(typeName, linkName, target), from, limit)
+}
+
+func (t *ReadTransaction) ListSlice(typeName string, from LogeKey, limit int) ResultSet {
+	t.checkOpen()
+	typ, ok := t.db.types[typeName]
+	if !ok {
+		panic(fmt.Sprintf("No such type %s\n", typeName))
+	}
+	return t.context.ListSlice(typePrefix(typ), from, limit)
+}
+
+
+// -----------------------------------------------
+// Internals
+// -----------------------------------------------
+
+func (t *ReadTransaction) checkOpen() {
+	if t.closed {
+		panic(fmt.Sprintf("Read from closed transaction %s\n", t))
+	}
+}
+
+func (t *ReadTransaction) readLinks(ref ObjRef) []string {
+	var blob = t.readBlob(ref)
+	var links linkList
+	if len(blob) > 0 {
+		spack.DecodeFromBytes(&links, t.db.linkTypeSpec, blob)
+	}
+	return links
+}
+
+// Uses the cached version if it covers the snapshot, otherwise the store
+func (t *ReadTransaction) readBlob(ref ObjRef) []byte {
+	t.checkOpen()
+	var db = t.db
+
+	var shard = db.cache.shard(ref.CacheKey)
+	var version *objectVersion
+	shard.lock.Lock()
+	if obj, ok := shard.objects[ref.CacheKey]; ok {
+		version = obj.visibleVersion(t.snapshotID)
+	}
+	shard.lock.Unlock()
+
+	// Loaded versions never change, so the blob is safe unlocked
+	if version != nil {
+		atomic.AddUint64(&db.cacheHits, 1)
+		db.metrics.Count(metric_CACHE_HITS, 1)
+		return version.Blob
+	}
+
+	atomic.AddUint64(&db.cacheMisses, 1)
+	db.metrics.Count(metric_CACHE_MISSES, 1)
+	return t.context.Get(ref)
+}
@@ -0,0 +1,85 @@
+package loge
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestReadTransaction(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Links = LinkSpec{ "other": "test" }
+	db.CreateType(def)
+
+	db.Transact(func (t *Transaction) {
+		t.Set("test", "one", &TestObj{ "One" })
+		t.AddLink("test", "other", "one", "two")
+	}, 0)
+
+	var reader = db.CreateReadTransaction()
+	if reader.Read("test", "one").(*TestObj).Name != "One" {
+		test.Error("Wrong object in read transaction")
+	}
+
+	// Nothing held, so writers carry on regardless
+	if db.CacheStats().Size != 0 {
+		test.Errorf("Read transaction holding objects: %v", db.CacheStats())
+	}
+
+	var writer = db.CreateTransaction()
+	writer.Write("test", "one").(*TestObj).Name = "Updated"
+	writer.RemoveLink("test", "other", "one", "two")
+	writer.Set("test", "two", &TestObj{ "Two" })
+	if !writer.Commit() {
+		test.Error("Writer failed alongside read transaction")
+	}
+
+	if reader.Read("test", "one").(*TestObj).Name != "One" {
+		test.Error("Read transaction saw later commit")
+	}
+	if reader.Exists("test", "two") {
+		test.Error("Read transaction saw later create")
+	}
+	if !reflect.DeepEqual(reader.ReadLinks("test", "other", "one"), []string{ "two" }) {
+		test.Error("Read transaction saw later link change")
+	}
+	if keys := reader.Find("test", "other", "two").All(); !reflect.DeepEqual(keys, []LogeKey{ "one" }) {
+		test.Errorf("Wrong find in read transaction: %v", keys)
+	}
+	reader.Close()
+
+	db.View(func (t *ReadTransaction) {
+		if t.Read("test", "one").(*TestObj).Name != "Updated" {
+			test.Error("New read transaction missed commit")
+		}
+		if t.HasLink("test", "other", "one", "two") {
+			test.Error("New read transaction missed link removal")
+		}
+	})
+}
+
+func TestReadSnapshotSkipsUnfinishedCommits(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	db.SetOne("test", "one", &TestObj{ "One" })
+
+	var committing = db.beginCommit()
+
+	var reader = db.CreateReadTransaction()
+	if reader.SnapshotID() >= committing {
+		test.Errorf("Read snapshot %d includes unfinished commit %d", reader.SnapshotID(), committing)
+	}
+	reader.Close()
+
+	db.endCommit(committing)
+
+	reader = db.CreateReadTransaction()
+	if reader.SnapshotID() != committing {
+		test.Errorf("Read snapshot %d behind finished commit %d", reader.SnapshotID(), committing)
+	}
+	reader.Close()
+
+	if len(db.activeSnapshots()) != 0 {
+		test.Errorf("Read snapshots left open: %v", db.activeSnapshots())
+	}
+}
@@ -0,0 +1,125 @@
+package loge
+
+import (
+	"fmt"
+)
+
+// A transaction's changes as of some point, to roll back to
+type Savepoint struct {
+	t *Transaction
+	versions map[string]savedVersion
+	onCommit int
+	scans int
+}
+
+type savedVersion struct {
+	object interface{}
+	dirty bool
+	merge bool
+	increments map[string]int64
+	linkDeltas map[string]bool
+}
+
+func (t *Transaction) Savepoint() *Savepoint {
+	if t.state != ACTIVE {
+		panic(fmt.Sprintf("Savepoint on inactive transaction %s\n", t))
+	}
+
+	var sp = &Savepoint{
+		t: t,
+		versions: make(map[string]savedVersion, len(t.versions)),
+		onCommit: len(t.onCommit),
+		scans: len(t.scans),
+	}
+	for key, lv := range t.versions {
+		sp.versions[key] = lv.save()
+	}
+	return sp
+}
+
+// Undoes everything since the savepoint, which stays valid, including
+// OnCommit callbacks and scans. Objects first read since then are still
+// checked at commit.
+func (sp *Savepoint) RollbackTo() {
+	var t = sp.t
+	if t.state != ACTIVE {
+		panic(fmt.Sprintf("Rollback on inactive transaction %s\n", t))
+	}
+
+	for key, lv := range t.versions {
+		if saved, ok := sp.versions[key]; ok {
+			lv.restore(saved)
+			continue
+		}
+
+		t.decodeVersion(lv)
+		lv.dirty = false
+		lv.merge = lv.merge || !lv.loaded
+		lv.increments = nil
+		lv.linkDeltas = nil
+	}
+
+	t.onCommit = t.onCommit[:sp.onCommit]
+	t.scans = t.scans[:sp.scans]
+}
+
+// Runs actor, rolling back its changes if it returns an error or
+// panics. Panics come back as errors.
+func (t *Transaction) Nested(actor func(*Transaction) error) (err error) {
+	var sp = t.Savepoint()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Nested transaction panicked: %v", r)
+		}
+		if err != nil && t.state == ACTIVE {
+			sp.RollbackTo()
+		}
+	}()
+
+	return actor(t)
+}
+
+// -----------------------------------------------
+// Internals
+// -----------------------------------------------
+
+func (lv *liveVersion) save() savedVersion {
+	return savedVersion{
+		object: deepCopy(lv.object),
+		dirty: lv.dirty,
+		merge: lv.merge,
+		increments: copyIncrements(lv.increments),
+		linkDeltas: copyLinkDeltas(lv.linkDeltas),
+	}
+}
+
+func (lv *liveVersion) restore(saved savedVersion) {
+	lv.object = deepCopy(saved.object)
+	lv.dirty = saved.dirty
+	lv.merge = saved.merge
+	lv.increments = copyIncrements(saved.increments)
+	lv.linkDeltas = copyLinkDeltas(saved.linkDeltas)
+}
+
+func copyIncrements(increments map[string]int64) map[string]int64 {
+	if increments == nil {
+		return nil
+	}
+	var copied = make(map[string]int64, len(increments))
+	for field, delta := range increments {
+		copied[field] = delta
+	}
+	return copied
+}
+
+func copyLinkDeltas(deltas map[string]bool) map[string]bool {
+	if deltas == nil {
+		return nil
+	}
+	var copied = make(map[string]bool, len(deltas))
+	for target, add := range deltas {
+		copied[target] = add
+	}
+	return copied
+}
@@ -0,0 +1,107 @@
+package loge
+
+import (
+	"testing"
+	"errors"
+	"reflect"
+)
+
+func TestSavepoint(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Links = LinkSpec{ "other": "test" }
+	db.CreateType(def)
+
+	db.Transact(func (t *Transaction) {
+		t.Set("test", "one", &TestObj{ "One" })
+		t.AddLink("test", "other", "one", "two")
+	}, 0)
+
+	var t = db.CreateTransaction()
+	t.Write("test", "one").(*TestObj).Name = "Kept"
+	t.AddLink("test", "other", "one", "three")
+
+	var sp = t.Savepoint()
+	t.Write("test", "one").(*TestObj).Name = "Undone"
+	t.RemoveLink("test", "other", "one", "two")
+	t.Set("test", "new", &TestObj{ "New" })
+	sp.RollbackTo()
+
+	if t.Read("test", "one").(*TestObj).Name != "Kept" {
+		test.Error("Write not rolled back")
+	}
+	if t.Exists("test", "new") {
+		test.Error("Set not rolled back")
+	}
+
+	// Still valid after rolling back once
+	t.RemoveLink("test", "other", "one", "three")
+	sp.RollbackTo()
+
+	if !t.Commit() {
+		test.Fatal("Commit failed after rollback")
+	}
+
+	if db.ReadOne("test", "one").(*TestObj).Name != "Kept" {
+		test.Error("Change before savepoint lost")
+	}
+	if db.ExistsOne("test", "new") {
+		test.Error("Rolled back object committed")
+	}
+	if links := db.ReadLinksOne("test", "other", "one"); !reflect.DeepEqual(links, []string{ "three", "two" }) {
+		test.Errorf("Wrong links after rollback: %v", links)
+	}
+	if keys := db.Find("test", "other", "two"); !reflect.DeepEqual(keys, []LogeKey{ "one" }) {
+		test.Errorf("Rolled back link removal reached index: %v", keys)
+	}
+}
+
+func TestNested(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	var called = false
+	db.Transact(func (t *Transaction) {
+		var err = t.Nested(func (t *Transaction) error {
+			t.Set("test", "failed", &TestObj{ "Failed" })
+			return errors.New("Failed")
+		})
+		if err == nil {
+			test.Error("Nested error lost")
+		}
+
+		err = t.Nested(func (t *Transaction) error {
+			t.Set("test", "panicked", &TestObj{ "Panicked" })
+			panic("Panicked")
+		})
+		if err == nil {
+			test.Error("Nested panic not returned")
+		}
+
+		err = t.Nested(func (t *Transaction) error {
+			t.OnCommit(func() {
+				test.Error("Rolled back callback ran")
+			})
+			return errors.New("Failed")
+		})
+		if err == nil {
+			test.Error("Nested error lost")
+		}
+
+		err = t.Nested(func (t *Transaction) error {
+			t.Set("test", "worked", &TestObj{ "Worked" })
+			t.OnCommit(func() { called = true })
+			return nil
+		})
+		if err != nil {
+			test.Errorf("Nested error: %v", err)
+		}
+	}, 0)
+
+	if db.ExistsOne("test", "failed") || db.ExistsOne("test", "panicked") {
+		test.Error("Failed nested transaction committed")
+	}
+	if !db.ExistsOne("test", "worked") || !called {
+		test.Error("Nested transaction lost")
+	}
+}
@@ -0,0 +1,110 @@
+package loge
+
+import (
+	"strings"
+)
+
+// Keys at or after from, under prefix. Limits are ignored, so a scan
+// covers everything it could have reached.
+type scanRange struct {
+	prefix string
+	from string
+}
+
+// Keys whose existence a commit changed: objects created or deleted,
+// and index entries added or removed.
+type commitRecord struct {
+	snapshotID uint64
+	keys []string
+}
+
+func (r scanRange) covers(key string) bool {
+	return strings.HasPrefix(key, r.prefix) && key[len(r.prefix):] >= r.from
+}
+
+// -----------------------------------------------
+// Transaction side
+// -----------------------------------------------
+
+func (t *Transaction) recordScan(prefix []byte, from LogeKey) {
+	if !t.db.serializable {
+		return
+	}
+	t.scans = append(t.scans, scanRange{ string(prefix), string(from) })
+}
+
+func (t *Transaction) recordIndexScan(ref ObjRef, from LogeKey) {
+	t.recordScan(encodeIndexKey(ref, ""), from)
+}
+
+// Call before applying versions, while they still hold what the
+// transaction read.
+func (t *Transaction) touchedKeys(versions []*liveVersion) []string {
+	var keys = make([]string, 0)
+
+	for _, lv := range versions {
+		if !lv.dirty {
+			continue
+		}
+
+		var obj = lv.version.LogeObj
+
+		if obj.LinkName != "" {
+			var links = lv.object.(*linkSet)
+			for _, targets := range []linkList{ links.Removed, links.Added } {
+				for _, target := range targets {
+					var ref = makeLinkRef(obj.Type, obj.LinkName, LogeKey(target))
+					keys = append(keys, string(encodeIndexKey(ref, obj.Key)))
+				}
+			}
+			continue
+		}
+
+		// Blind writes never read the old value, so count as changes
+		var existed = len(lv.blob) > 0
+		if !lv.loaded || existed != obj.hasValue(lv.object) {
+			keys = append(keys, obj.makeObjRef().CacheKey)
+		}
+	}
+
+	return keys
+}
+
+// -----------------------------------------------
+// Commit log
+// -----------------------------------------------
+
+// The first scanned key a commit after sID touched, if any. Called with
+// scanLock held.
+func (db *LogeDB) scanConflict(sID uint64, scans []scanRange) (string, bool) {
+	for _, record := range db.recentCommits {
+		if record.snapshotID <= sID {
+			continue
+		}
+		for _, key := range record.keys {
+			for _, scan := range scans {
+				if scan.covers(key) {
+					return key, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Records are kept while a transaction from before them is open.
+// Called with scanLock held.
+func (db *LogeDB) recordCommit(sID uint64, keys []string) {
+	var oldest = db.OldestSnapshotID()
+	var kept = db.recentCommits[:0]
+	for _, record := range db.recentCommits {
+		if record.snapshotID > oldest {
+			kept = append(kept, record)
+		}
+	}
+
+	if len(keys) > 0 {
+		kept = append(kept, commitRecord{ sID, keys })
+	}
+	db.recentCommits = kept
+}
@@ -0,0 +1,91 @@
+package loge
+
+import (
+	"testing"
+)
+
+func TestSerializableFind(test *testing.T) {
+	for _, serializable := range []bool{ false, true } {
+		var db = newSerializableTest(serializable)
+
+		// Both check nobody owns a pet yet, then adopt one
+		var adopt = func(pet LogeKey) *Transaction {
+			var t = db.CreateTransaction()
+			if len(t.Find("pet", "owner", "bob").All()) != 0 {
+				test.Error("Bob already has a pet")
+			}
+			t.Set("pet", pet, &TestObj{ string(pet) })
+			t.AddLink("pet", "owner", pet, "bob")
+			return t
+		}
+
+		var first = adopt("rex")
+		var second = adopt("tiddles")
+
+		if !first.Commit() {
+			test.Error("First adoption failed")
+		}
+		if second.Commit() == serializable {
+			test.Errorf("Second adoption (serializable %v) ended %v", serializable, second.GetState())
+		}
+	}
+}
+
+func TestSerializableListSlice(test *testing.T) {
+	var db = newSerializableTest(true)
+	db.SetOne("pet", "rex", &TestObj{ "Rex" })
+
+	var scan = func() *Transaction {
+		var t = db.CreateTransaction()
+		t.ListSlice("pet", "", -1).All()
+		t.Set("owner", "count", &TestObj{ "" })
+		return t
+	}
+
+	// Updates don't change what a scan sees
+	var t = scan()
+	db.Transact(func (t *Transaction) {
+		t.Write("pet", "rex").(*TestObj).Name = "Updated"
+	}, 0)
+	if !t.Commit() {
+		test.Error("Update aborted scan")
+	}
+
+	// Nor do changes elsewhere
+	t = scan()
+	db.SetOne("owner", "bob", &TestObj{ "Bob" })
+	if !t.Commit() {
+		test.Error("Other type aborted scan")
+	}
+
+	t = scan()
+	db.SetOne("pet", "tiddles", &TestObj{ "Tiddles" })
+	if t.Commit() {
+		test.Error("Create didn't abort scan")
+	}
+
+	t = scan()
+	db.DeleteOne("pet", "rex")
+	if t.Commit() {
+		test.Error("Delete didn't abort scan")
+	}
+
+	// Before the scan's range
+	db.SetOne("pet", "zed", &TestObj{ "Zed" })
+	t = db.CreateTransaction()
+	t.ListSlice("pet", "x", -1).All()
+	t.Set("owner", "count", &TestObj{ "" })
+	db.SetOne("pet", "alf", &TestObj{ "Alf" })
+	if !t.Commit() {
+		test.Error("Create before range aborted scan")
+	}
+}
+
+func newSerializableTest(serializable bool) *LogeDB {
+	var db = NewLogeDBWithOptions(NewMemStore(), DBOptions{ Serializable: serializable })
+	db.CreateType(NewTypeDef("owner", 1, &TestObj{}))
+	var def = NewTypeDef("pet", 1, &TestObj{})
+	def.Links = LinkSpec{ "owner": "owner" }
+	db.CreateType(def)
+	return db
+}
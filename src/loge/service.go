@@ -42,6 +42,13 @@ func GetService() *Service {
 		  APIArg{Name: "key", ArgType: StringArg},
 	    },
 		method_get)
+	service.AddMethod(
+		"changes",
+		[]APIArg {
+		  APIArg{Name: "since", ArgType: UIntArg, Default: 0},
+		  APIArg{Name: "limit", ArgType: UIntArg, Default: -1},
+	    },
+		method_changes)
 
 	return service
 }
@@ -62,9 +69,19 @@ func method_info(args APIData, session Session, context ServerContext) (bool, AP
 		types = append(types, typeName)
 	}
 
+	db.UpdateGauges()
+
 	var response = make(APIData)
 	response["DB"] = dbInfo
 	response["Types"] = types
+	response["Cache"] = db.CacheStats()
+	response["Store"] = db.store.Stats()
+	if metrics, ok := db.metrics.(*MemMetrics); ok {
+		response["Metrics"] = metrics.Snapshot()
+	}
+	if ldbStore, ok := db.store.(*levelDBStore); ok {
+		response["LevelDBStats"] = ldbStore.engine.property("leveldb.stats")
+	}
 	return true, response
 }
 
@@ -101,14 +118,14 @@ func method_get(args APIData, session Session, context ServerContext) (bool, API
 
 	var obj interface{}
 	var links  = make(map[string][]string)
-	db.TransactJSON(func (t *Transaction) {
+	db.ViewJSON(func (t *ReadTransaction) {
 		obj = t.Read(typeName, key)
 		if obj != nil {
 			for linkName := range db.types[typeName].Links {
 				links[linkName] = t.ReadLinks(typeName, linkName, key)
 			}
 		}
-	}, 0)
+	})
 
 	if obj == nil {
 		response["found"] = false
@@ -119,3 +136,32 @@ func method_get(args APIData, session Session, context ServerContext) (bool, API
 	}
 	return true, response
 }	
+
+func method_changes(args APIData, session Session, context ServerContext) (bool, APIData) {
+	var db = context.(LogeServiceContext).DB()
+	var response = make(APIData)
+
+	var since = uint64(args["since"].(int))
+	var changes, err = db.ChangesSince(since, args["limit"].(int))
+	if err != nil {
+		response["error"] = err.Error()
+		return false, response
+	}
+
+	var list = make([]APIData, 0, len(changes))
+	for _, change := range changes {
+		since = change.SnapshotID
+		list = append(list, APIData{
+			"snapshot": change.SnapshotID,
+			"type": change.TypeName,
+			"key": string(change.Key),
+			"link": change.LinkName,
+			"old": change.decode(change.OldBlob, true),
+			"new": change.decode(change.NewBlob, true),
+		})
+	}
+
+	response["changes"] = list
+	response["last"] = since
+	return true, response
+}
@@ -0,0 +1,62 @@
+package loge
+
+import (
+	"testing"
+
+	. "github.com/brendonh/go-service"
+)
+
+type testServiceContext struct {
+	ServerContext
+	db *LogeDB
+}
+
+func (context *testServiceContext) DB() *LogeDB {
+	return context.db
+}
+
+func TestServiceChanges(test *testing.T) {
+	var options = DefaultLevelDBOptions()
+	options.ChangeLog = true
+	var db = openStoreTest(NewGoLevelDBStoreWithOptions(test.TempDir(), options))
+	defer db.Close()
+	var context = &testServiceContext{ db: db }
+
+	db.SetOne("test", "one", &TestObj{ "One" })
+	db.Transact(func (t *Transaction) {
+		t.Set("test", "two", &TestObj{ "Two" })
+		t.AddLink("test", "other", "two", "one")
+	}, 0)
+
+	var ok, response = method_changes(APIData{ "since": 0, "limit": 1 }, nil, context)
+	if !ok {
+		test.Fatalf("Changes failed: %v", response)
+	}
+	var changes = response["changes"].([]APIData)
+	if len(changes) != 1 || changes[0]["key"] != "one" || changes[0]["type"] != "test" ||
+		changes[0]["old"] != nil || changes[0]["new"] == nil {
+		test.Fatalf("Wrong first page: %v", changes)
+	}
+	if response["last"] != changes[0]["snapshot"] {
+		test.Errorf("Wrong cursor: %v", response["last"])
+	}
+
+	var last = response["last"].(uint64)
+	ok, response = method_changes(APIData{ "since": int(last), "limit": -1 }, nil, context)
+	changes = response["changes"].([]APIData)
+	if !ok || len(changes) != 2 || changes[1]["link"] != "other" {
+		test.Fatalf("Wrong second page: %v", changes)
+	}
+
+	last = response["last"].(uint64)
+	ok, response = method_changes(APIData{ "since": int(last), "limit": -1 }, nil, context)
+	if !ok || len(response["changes"].([]APIData)) != 0 || response["last"] != last {
+		test.Errorf("Wrong empty page: %v", response)
+	}
+
+	var plain = &testServiceContext{ db: NewLogeDB(NewMemStore()) }
+	ok, response = method_changes(APIData{ "since": 0, "limit": -1 }, nil, plain)
+	if ok || response["error"] != ErrNoChangeLog.Error() {
+		test.Errorf("Changes without a log: %v", response)
+	}
+}
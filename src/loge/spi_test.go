@@ -0,0 +1,62 @@
+package loge_test
+
+import (
+	"testing"
+
+	"loge"
+)
+
+// A store decorated from outside the package, through the public SPI
+type countingStore struct {
+	loge.LogeStore
+	stores int
+}
+
+type countingContext struct {
+	loge.TransactionContext
+	store *countingStore
+}
+
+func (store *countingStore) NewContext(sID uint64) loge.TransactionContext {
+	return &countingContext{ store.LogeStore.NewContext(sID), store }
+}
+
+func (context *countingContext) Store(ref loge.ObjRef, blob []byte) error {
+	context.store.stores++
+	return context.TransactionContext.Store(ref, blob)
+}
+
+func TestExternalStore(test *testing.T) {
+	var store = &countingStore{ LogeStore: loge.NewMemStore() }
+	var db = loge.NewLogeDB(store)
+	db.CreateType(loge.NewTypeDef("test", 1, &struct{ Name string }{}))
+
+	db.SetOne("test", "one", &struct{ Name string }{ "One" })
+	db.ReadOne("test", "one")
+
+	if store.stores != 1 {
+		test.Errorf("Wrong store count: %d", store.stores)
+	}
+}
+
+// Leaves link tags alone, which would collide with objects
+type untaggedStore struct {
+	loge.LogeStore
+}
+
+func (store *untaggedStore) RegisterType(typ *loge.LogeType) {
+}
+
+func TestUntaggedLinks(test *testing.T) {
+	var db = loge.NewLogeDB(&untaggedStore{ loge.NewMemStore() })
+
+	defer func() {
+		if recover() == nil {
+			test.Error("Untagged links allowed")
+		}
+	}()
+
+	var def = loge.NewTypeDef("test", 1, &struct{ Name string }{})
+	def.Links = loge.LinkSpec{ "other": "test" }
+	db.CreateType(def)
+}
@@ -1,14 +1,61 @@
 package loge
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/brendonh/spack"
 )
 
+// The storage SPI. Stores only ever see encoded blobs, addressed by
+// ObjRef.CacheKey; LogeDB handles caching, conflicts and decoding.
+// Object history (TypeDef.KeepVersions) goes through the same contexts,
+// under binary CacheKeys belonging to no type.
+// See loge/storetest for a conformance suite.
 type LogeStore interface {
-	close()
-	registerType(*logeType)
-	getSpackType(name string) *spack.VersionedType
-	newContext(uint64) transactionContext
+	Close()
+	// Called once per CreateType. The store must give every link in
+	// typ.Links a non-zero Tag, unique within the type, and should
+	// persist whatever it needs to keep tags stable.
+	RegisterType(typ *LogeType)
+	// Type metadata for name, shared by every DB using the store
+	GetSpackType(name string) *spack.VersionedType
+	// A context reading as of snapshot sID, which must not see any
+	// later commit.
+	NewContext(sID uint64) TransactionContext
+	// Drops versions no snapshot in active (ascending) can see, and
+	// returns how many went.
+	CollectVersions(active SnapshotIDs) int
+	// Gauges exported alongside the DB's own metrics
+	Stats() map[string]float64
+	SetLogger(logger Logger)
+}
+
+// Optional, for stores which keep snapshot IDs going across restarts.
+// LogeDB carries on after the last one, so IDs recorded in the change
+// log and object history never repeat. Stores which keep nothing on
+// close needn't bother.
+type SnapshotStore interface {
+	// The highest snapshot ID committed, 0 for none
+	LastSnapshotID() uint64
+}
+
+// Optional, for stores keeping a durable log of committed changes, which
+// LogeDB.ChangesSince reads. Their contexts implement ChangeLogContext.
+type ChangeLogStore interface {
+	SnapshotStore
+	// Stores may leave the log switched off
+	KeepsChanges() bool
+	// Changes after sID, up to and including upTo, oldest first. Whole
+	// commits only, stopping once there are at least limit changes (-1
+	// for all). ErrChangesPruned if some after sID are gone.
+	ChangesSince(sID uint64, upTo uint64, limit int) ([]ChangeEvent, error)
+}
+
+type ChangeLogContext interface {
+	// Called before Commit, for each change it makes, in order. Stores
+	// need only keep the exported fields.
+	LogChange(event ChangeEvent)
 }
 
 type ResultSet interface {
@@ -18,22 +65,30 @@ type ResultSet interface {
 	Close()
 }
 
-type transactionContext interface {
-	getSnapshotID() uint64
+// One transaction's view of a store. Writes are buffered until Commit,
+// which LogeDB calls with the objects locked and conflicts checked.
+type TransactionContext interface {
+	SnapshotID() uint64
 
-	get(objRef) []byte
-	store(objRef, []byte) error
+	// nil for missing objects
+	Get(ref ObjRef) []byte
+	// An empty blob deletes
+	Store(ref ObjRef, blob []byte) error
 
-	addIndex(objRef, LogeKey)
-	remIndex(objRef, LogeKey)
+	// Indexes source as linking to ref (a link ref for the target key)
+	AddIndex(ref ObjRef, source LogeKey)
+	RemoveIndex(ref ObjRef, source LogeKey)
 
-	find(objRef) ResultSet
-	findSlice(objRef, LogeKey, int) ResultSet
+	// Sources linking to ref, in key order
+	Find(ref ObjRef) ResultSet
+	// As Find, starting after from, with at most limit results (-1 for all)
+	FindSlice(ref ObjRef, from LogeKey, limit int) ResultSet
 
-	listSlice([]byte, LogeKey, int) ResultSet
+	// Keys of live objects whose CacheKey starts with prefix, in order
+	ListSlice(prefix []byte, from LogeKey, limit int) ResultSet
 
-	commit(uint64) error
-	rollback()
+	Commit(sID uint64) error
+	Rollback()
 }
 
 type memVersion struct {
@@ -56,8 +111,13 @@ type objectMap map[string]memVersionHistory
 
 type memStore struct {
 	objects objectMap
+	indexes objectMap
+	metadata objectMap
 	lock spinLock
 	spackTypes *spack.TypeSet
+	log *memLog
+	logger Logger
+	lastSnapshotID uint64
 }
 
 type memContext struct {
@@ -69,40 +129,198 @@ type memContext struct {
 type memWriteEntry struct {
 	CacheKey string
 	Value []byte
+	Space memSpace
+}
+
+type memSpace byte
+
+const (
+	mem_OBJECTS memSpace = iota
+	mem_INDEXES
+	mem_METADATA
+	mem_NUM_SPACES
+)
+
+type memResultSet struct {
+	keys []LogeKey
 }
 
+var memIndexPresent = []byte{}
+
 func NewMemStore() LogeStore {
+	return NewMemStoreWithOptions(DefaultMemStoreOptions())
+}
+
+func newMemStore() *memStore {
 	return &memStore{
 		objects: make(objectMap),
-		spackTypes: spack.NewTypeSet(),
+		indexes: make(objectMap),
+		metadata: make(objectMap),
+		spackTypes: newMetadataTypeSet(),
 	}
 }
 
-func (store *memStore) close() {
+func (store *memStore) Close() {
+	if store.log == nil {
+		return
+	}
+
+	store.log.lock.Lock()
+	defer store.log.lock.Unlock()
+
+	if store.log.records > 0 {
+		var err = store.writeSnapshot()
+		if err != nil {
+			store.logger.Error("Snapshot failed", "path", store.log.snapshotPath(), "error", err)
+		}
+	}
+
+	var err = store.log.close()
+	if err != nil {
+		store.logger.Error("Couldn't close commit log", "error", err)
+	}
 }
 
-func (store *memStore) registerType(typ *logeType) {
-	store.spackTypes.RegisterType(typ.Name)
+func (store *memStore) RegisterType(typ *LogeType) {
+	registerTypeMetadata(store.spackTypes, store, typ, store.logger)
 }
 
-func (store *memStore) getSpackType(name string) *spack.VersionedType {
+func (store *memStore) GetSpackType(name string) *spack.VersionedType {
 	return store.spackTypes.RegisterType(name)
 }
 
+// Metadata is logged like any other write, under the same keys as in
+// LevelDB
+func (store *memStore) iterateMetadata(prefix []byte, each func(val []byte)) {
+	store.lock.SpinLock()
+	var keys = make([]string, 0)
+	for key := range store.metadata {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var vals = make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		var mvh = store.metadata[key]
+		if blob := mvh[len(mvh) - 1].blob; blob != nil {
+			vals = append(vals, blob)
+		}
+	}
+	store.lock.Unlock()
+
+	for _, val := range vals {
+		each(val)
+	}
+}
+
+func (store *memStore) putMetadata(key []byte, val []byte) error {
+	var writes = []memWriteEntry{ memWriteEntry{ string(key), val, mem_METADATA } }
+	if store.log != nil {
+		return store.logCommit(writes, 0)
+	}
+	store.apply(writes, 0)
+	return nil
+}
+
+func (store *memStore) LastSnapshotID() uint64 {
+	store.lock.SpinLock()
+	defer store.lock.Unlock()
+	return store.lastSnapshotID
+}
+
+func (store *memStore) CollectVersions(active SnapshotIDs) int {
+	store.lock.SpinLock()
+	defer store.lock.Unlock()
+
+	return store.objects.prune(active) + store.indexes.prune(active)
+}
+
+func (objects objectMap) prune(active SnapshotIDs) int {
+	var pruned = 0
+	for key, mvh := range objects {
+		var kept = mvh.prune(active)
+		pruned += len(mvh) - len(kept)
+
+		// A lone deletion reads the same as no history at all
+		if len(kept) == 1 && kept[0].blob == nil {
+			delete(objects, key)
+			pruned++
+			continue
+		}
+
+		objects[key] = kept
+	}
+	return pruned
+}
+
+// Keys after from with the given prefix, which exist at sID, in order
+func (objects objectMap) list(prefix []byte, from LogeKey, limit int, sID uint64) ResultSet {
+	var keys = make([]string, 0)
+	if limit != 0 {
+		var start = string(prefix) + string(from)
+		for key, mvh := range objects {
+			if !strings.HasPrefix(key, string(prefix)) || (from != "" && key <= start) {
+				continue
+			}
+			if mvh.findPrevious(sID) != nil {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	var results = make([]LogeKey, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, LogeKey(key[len(prefix):]))
+	}
+	return &memResultSet{ results }
+}
+
+func (store *memStore) Stats() map[string]float64 {
+	var logRecords = 0
+	if store.log != nil {
+		store.log.lock.Lock()
+		logRecords = store.log.records
+		store.log.lock.Unlock()
+	}
+
+	store.lock.SpinLock()
+	defer store.lock.Unlock()
+
+	var stats = map[string]float64{
+		"loge_memstore_keys": float64(len(store.objects)),
+	}
+	if store.log != nil {
+		stats["loge_memstore_log_records"] = float64(logRecords)
+	}
+	return stats
+}
+
+func (store *memStore) SetLogger(logger Logger) {
+	store.logger = logger
+}
 
-func (store *memStore) newContext(sID uint64) transactionContext {
+func (store *memStore) NewContext(sID uint64) TransactionContext {
 	return &memContext{
 		mstore: store,
 		snapshotID: sID,
 	}
 }
 
-func (context *memContext) getSnapshotID() uint64 {
+func (context *memContext) SnapshotID() uint64 {
 	return context.snapshotID
 }
 
 
-func (context *memContext) get(ref objRef) []byte {
+func (context *memContext) Get(ref ObjRef) []byte {
+	context.mstore.lock.SpinLock()
+	defer context.mstore.lock.Unlock()
 	mvh, ok := context.mstore.objects[ref.CacheKey]
 	if !ok {
 		return nil
@@ -110,7 +328,7 @@ func (context *memContext) get(ref objRef) []byte {
 	return mvh.findPrevious(context.snapshotID)
 }
 
-func (context *memContext) store(ref objRef, enc []byte) error {
+func (context *memContext) Store(ref ObjRef, enc []byte) error {
 	context.writes = append(
 		context.writes,
 		memWriteEntry{ 
@@ -121,37 +339,100 @@ func (context *memContext) store(ref objRef, enc []byte) error {
 }
 
 
-func (context *memContext) addIndex(ref objRef, key LogeKey) {
+func (context *memContext) AddIndex(ref ObjRef, key LogeKey) {
+	context.storeIndex(ref, key, memIndexPresent)
 }
 
-func (context *memContext) remIndex(ref objRef, key LogeKey) {
+func (context *memContext) RemoveIndex(ref ObjRef, key LogeKey) {
+	context.storeIndex(ref, key, nil)
+}
+
+func (context *memContext) storeIndex(ref ObjRef, key LogeKey, value []byte) {
+	context.writes = append(
+		context.writes,
+		memWriteEntry{
+		CacheKey: string(encodeIndexKey(ref, key)),
+		Value: value,
+		Space: mem_INDEXES,
+	})
 }
-func (context *memContext) find(ref objRef) ResultSet {
-	// Until I can be bothered
-	panic("Find not implemented on memstore")
+
+func (context *memContext) Find(ref ObjRef) ResultSet {
+	return context.FindSlice(ref, "", -1)
 }
 
-func (context *memContext) findSlice(ref objRef, from LogeKey, limit int) ResultSet {
-	// Until I can be bothered
-	panic("Find not implemented on memstore")
+func (context *memContext) FindSlice(ref ObjRef, from LogeKey, limit int) ResultSet {
+	context.mstore.lock.SpinLock()
+	defer context.mstore.lock.Unlock()
+	return context.mstore.indexes.list(encodeIndexKey(ref, ""), from, limit, context.snapshotID)
 }
 
-func (context *memContext) listSlice(prefix []byte, from LogeKey, limit int) ResultSet {
-	// Until I can be bothered
-	panic("List not implemented on memstore")
+func (context *memContext) ListSlice(prefix []byte, from LogeKey, limit int) ResultSet {
+	context.mstore.lock.SpinLock()
+	defer context.mstore.lock.Unlock()
+	return context.mstore.objects.list(prefix, from, limit, context.snapshotID)
 }
 
-func (context *memContext) commit(sID uint64) error {
+
+func (context *memContext) Commit(sID uint64) error {
 	var store = context.mstore
+	if store.log != nil && len(context.writes) > 0 {
+		return store.logCommit(context.writes, sID)
+	}
+	store.apply(context.writes, sID)
+	return nil
+}
+
+func (store *memStore) space(space memSpace) objectMap {
+	switch space {
+	case mem_INDEXES:
+		return store.indexes
+	case mem_METADATA:
+		return store.metadata
+	}
+	return store.objects
+}
+
+func (store *memStore) apply(writes []memWriteEntry, sID uint64) {
 	store.lock.SpinLock()
 	defer store.lock.Unlock()
-	for _, entry := range context.writes {
+	if sID > store.lastSnapshotID {
+		store.lastSnapshotID = sID
+	}
+	for _, entry := range writes {
+		var objects = store.space(entry.Space)
 		var mv = memVersion{ sID, entry.Value }
-		var mvh = store.objects[entry.CacheKey]
-		store.objects[entry.CacheKey] = append(mvh, mv)
+		objects[entry.CacheKey] = append(objects[entry.CacheKey], mv)
 	}
-	return nil
 }
 
-func (context *memContext) rollback() {
+func (context *memContext) Rollback() {
+}
+
+
+// -----------------------------------------------
+// Search
+// -----------------------------------------------
+
+func (rs *memResultSet) Valid() bool {
+	return len(rs.keys) > 0
+}
+
+func (rs *memResultSet) Next() LogeKey {
+	if len(rs.keys) == 0 {
+		return ""
+	}
+	var next = rs.keys[0]
+	rs.keys = rs.keys[1:]
+	return next
+}
+
+func (rs *memResultSet) All() []LogeKey {
+	var keys = rs.keys
+	rs.keys = nil
+	return keys
+}
+
+func (rs *memResultSet) Close() {
+	rs.keys = nil
 }
\ No newline at end of file
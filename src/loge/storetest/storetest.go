@@ -0,0 +1,564 @@
+// Conformance tests for LogeStore implementations. Every backend should
+// pass these identically:
+//
+//	func TestMyStore(test *testing.T) {
+//		storetest.Run(test, func(dir string) loge.LogeStore {
+//			return NewMyStore(dir)
+//		}, storetest.Options{})
+//	}
+package storetest
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"loge"
+)
+
+// Opens a store kept in dir, which is empty on first use. Opening the
+// same dir after the store is closed should see everything committed.
+type Factory func(dir string) loge.LogeStore
+
+type Options struct {
+	// Skips the reopen tests, for stores which keep nothing on close
+	Volatile bool
+	// Concurrent writers in the concurrency test. Defaults to 8.
+	Writers int
+}
+
+type TestObj struct {
+	Name string
+}
+
+type TestCounter struct {
+	Value uint32
+}
+
+type suite struct {
+	factory Factory
+	options Options
+}
+
+func Run(test *testing.T, factory Factory, options Options) {
+	if options.Writers <= 0 {
+		options.Writers = 8
+	}
+
+	var s = &suite{ factory, options }
+
+	test.Run("Commit", s.testCommit)
+	test.Run("Snapshots", s.testSnapshots)
+	test.Run("Conflicts", s.testConflicts)
+	test.Run("Deletes", s.testDeletes)
+	test.Run("LinkIndexes", s.testLinkIndexes)
+	test.Run("FindSlice", s.testFindSlice)
+	test.Run("ListSlice", s.testListSlice)
+	test.Run("Reopen", s.testReopen)
+	test.Run("Concurrency", s.testConcurrency)
+	test.Run("SnapshotIDs", s.testSnapshotIDs)
+	test.Run("ChangeLog", s.testChangeLog)
+	test.Run("History", s.testHistory)
+}
+
+func (s *suite) open(test *testing.T, dir string) *loge.LogeDB {
+	var db, _ = s.openStore(test, dir)
+	return db
+}
+
+// Also returns the store, for the optional interfaces
+func (s *suite) openStore(test *testing.T, dir string) (*loge.LogeDB, loge.LogeStore) {
+	var store = s.factory(dir)
+	var db = loge.NewLogeDB(store)
+
+	var def = loge.NewTypeDef("obj", 1, &TestObj{})
+	def.Links = loge.LinkSpec{ "other": "obj" }
+	db.CreateType(def)
+	db.CreateType(loge.NewTypeDef("counter", 1, &TestCounter{}))
+
+	return db, store
+}
+
+func (s *suite) openTemp(test *testing.T) *loge.LogeDB {
+	var db = s.open(test, test.TempDir())
+	test.Cleanup(db.Close)
+	return db
+}
+
+
+// -----------------------------------------------
+// Tests
+// -----------------------------------------------
+
+func (s *suite) testCommit(test *testing.T) {
+	var db = s.openTemp(test)
+
+	var trans = db.CreateTransaction()
+	trans.Set("obj", "one", &TestObj{ "One" })
+	if trans.Read("obj", "one").(*TestObj).Name != "One" {
+		test.Error("Created object missing in transaction")
+	}
+	if !trans.Commit() {
+		test.Fatal("Commit failed")
+	}
+
+	if !db.ExistsOne("obj", "one") {
+		test.Error("Created object missing after commit")
+	}
+
+	db.Transact(func (t *loge.Transaction) {
+		t.Write("obj", "one").(*TestObj).Name = "Updated"
+	}, 0)
+
+	expectName(test, db, "one", "Updated")
+
+	trans = db.CreateTransaction()
+	trans.Set("obj", "two", &TestObj{ "Two" })
+	trans.Cancel()
+
+	if db.ExistsOne("obj", "two") {
+		test.Error("Cancelled write visible")
+	}
+}
+
+func (s *suite) testSnapshots(test *testing.T) {
+	var db = s.openTemp(test)
+	db.SetOne("obj", "one", &TestObj{ "One" })
+
+	var reader = db.CreateTransaction()
+	var writer = db.CreateTransaction()
+
+	writer.Write("obj", "one").(*TestObj).Name = "Updated"
+	writer.Set("obj", "two", &TestObj{ "Two" })
+
+	if reader.Read("obj", "one").(*TestObj).Name != "One" {
+		test.Error("Uncommitted update visible")
+	}
+
+	if !writer.Commit() {
+		test.Fatal("Commit failed")
+	}
+
+	if reader.Exists("obj", "two") {
+		test.Error("Object created after snapshot visible")
+	}
+	if reader.Read("obj", "one").(*TestObj).Name != "One" {
+		test.Error("Update committed after snapshot visible")
+	}
+	if keys := reader.ListSlice("obj", "", -1).All(); !reflect.DeepEqual(keys, []loge.LogeKey{ "one" }) {
+		test.Errorf("Wrong keys listed at snapshot: %v", keys)
+	}
+	reader.Commit()
+
+	expectName(test, db, "one", "Updated")
+	expectName(test, db, "two", "Two")
+}
+
+func (s *suite) testConflicts(test *testing.T) {
+	var db = s.openTemp(test)
+	db.SetOne("obj", "one", &TestObj{ "One" })
+
+	var trans1 = db.CreateTransaction()
+	var trans2 = db.CreateTransaction()
+
+	trans1.Write("obj", "one").(*TestObj).Name = "First"
+	trans2.Write("obj", "one").(*TestObj).Name = "Second"
+
+	if !trans1.Commit() {
+		test.Fatal("First commit failed")
+	}
+	if trans2.Commit() {
+		test.Error("Conflicting commit succeeded")
+	}
+
+	expectName(test, db, "one", "First")
+
+	trans1 = db.CreateTransaction()
+	trans2 = db.CreateTransaction()
+
+	trans1.Set("obj", "two", &TestObj{ "First" })
+	trans2.Set("obj", "two", &TestObj{ "Second" })
+
+	if !trans1.Commit() {
+		test.Fatal("First create failed")
+	}
+	if trans2.Commit() {
+		test.Error("Double create succeeded")
+	}
+
+	expectName(test, db, "two", "First")
+}
+
+func (s *suite) testDeletes(test *testing.T) {
+	var db = s.openTemp(test)
+	db.SetOne("obj", "one", &TestObj{ "One" })
+
+	var reader = db.CreateTransaction()
+
+	db.DeleteOne("obj", "one")
+
+	if db.ExistsOne("obj", "one") {
+		test.Error("Deleted object exists")
+	}
+	if db.ReadOne("obj", "one").(*TestObj) != nil {
+		test.Error("Deleted object readable")
+	}
+	if keys := db.ListSlice("obj", "", -1); len(keys) != 0 {
+		test.Errorf("Deleted object listed: %v", keys)
+	}
+
+	if !reader.Exists("obj", "one") {
+		test.Error("Delete visible to earlier snapshot")
+	}
+	reader.Commit()
+
+	db.SetOne("obj", "one", &TestObj{ "Again" })
+	expectName(test, db, "one", "Again")
+}
+
+func (s *suite) testLinkIndexes(test *testing.T) {
+	var db = s.openTemp(test)
+
+	db.Transact(func (t *loge.Transaction) {
+		t.Set("obj", "one", &TestObj{ "One" })
+		t.Set("obj", "two", &TestObj{ "Two" })
+		t.Set("obj", "three", &TestObj{ "Three" })
+		t.AddLink("obj", "other", "one", "three")
+		t.AddLink("obj", "other", "two", "three")
+		t.AddLink("obj", "other", "three", "one")
+	}, 0)
+
+	expectKeys(test, "find", db.Find("obj", "other", "three"), "one", "two")
+	expectKeys(test, "find", db.Find("obj", "other", "one"), "three")
+	expectKeys(test, "find", db.Find("obj", "other", "two"))
+
+	var reader = db.CreateTransaction()
+
+	db.Transact(func (t *loge.Transaction) {
+		t.RemoveLink("obj", "other", "one", "three")
+		t.SetLinks("obj", "other", "two", []loge.LogeKey{ "one" })
+	}, 0)
+
+	expectKeys(test, "find after update", db.Find("obj", "other", "three"))
+	expectKeys(test, "find after update", db.Find("obj", "other", "one"), "three", "two")
+
+	expectKeys(test, "find at snapshot", reader.Find("obj", "other", "three").All(), "one", "two")
+	if links := reader.ReadLinks("obj", "other", "two"); !reflect.DeepEqual(links, []string{ "three" }) {
+		test.Errorf("Wrong links at snapshot: %v", links)
+	}
+	reader.Commit()
+}
+
+func (s *suite) testFindSlice(test *testing.T) {
+	var db = s.openTemp(test)
+
+	db.Transact(func (t *loge.Transaction) {
+		t.Set("obj", "target", &TestObj{ "Target" })
+		for _, key := range []loge.LogeKey{ "e", "b", "d", "a", "c" } {
+			t.Set("obj", key, &TestObj{ string(key) })
+			t.AddLink("obj", "other", key, "target")
+		}
+	}, 0)
+
+	expectKeys(test, "first page", db.FindSlice("obj", "other", "target", "", 2), "a", "b")
+	expectKeys(test, "second page", db.FindSlice("obj", "other", "target", "b", 2), "c", "d")
+	expectKeys(test, "last page", db.FindSlice("obj", "other", "target", "d", 2), "e")
+	expectKeys(test, "past end", db.FindSlice("obj", "other", "target", "e", 2))
+	expectKeys(test, "zero limit", db.FindSlice("obj", "other", "target", "", 0))
+	expectKeys(test, "no limit", db.FindSlice("obj", "other", "target", "a", -1), "b", "c", "d", "e")
+
+	db.Transact(func (t *loge.Transaction) {
+		var results = t.FindSlice("obj", "other", "target", "", 3)
+		var keys []loge.LogeKey
+		for results.Valid() {
+			keys = append(keys, results.Next())
+		}
+		results.Close()
+		expectKeys(test, "iterated", keys, "a", "b", "c")
+	}, 0)
+}
+
+func (s *suite) testListSlice(test *testing.T) {
+	var db = s.openTemp(test)
+
+	db.Transact(func (t *loge.Transaction) {
+		for _, key := range []loge.LogeKey{ "e", "b", "d", "a", "c" } {
+			t.Set("obj", key, &TestObj{ string(key) })
+		}
+		t.Set("counter", "x", &TestCounter{ 1 })
+		t.AddLink("obj", "other", "a", "b")
+	}, 0)
+
+	expectKeys(test, "first page", db.ListSlice("obj", "", 2), "a", "b")
+	expectKeys(test, "second page", db.ListSlice("obj", "b", 2), "c", "d")
+	expectKeys(test, "last page", db.ListSlice("obj", "d", 2), "e")
+	expectKeys(test, "past end", db.ListSlice("obj", "e", 2))
+	expectKeys(test, "zero limit", db.ListSlice("obj", "", 0))
+	expectKeys(test, "all", db.ListSlice("obj", "", -1), "a", "b", "c", "d", "e")
+	expectKeys(test, "other type", db.ListSlice("counter", "", -1), "x")
+}
+
+func (s *suite) testReopen(test *testing.T) {
+	if s.options.Volatile {
+		test.Skip("Store is volatile")
+	}
+
+	var dir = test.TempDir()
+	var db = s.open(test, dir)
+
+	db.Transact(func (t *loge.Transaction) {
+		t.Set("obj", "one", &TestObj{ "One" })
+		t.Set("obj", "two", &TestObj{ "Two" })
+		t.Set("counter", "x", &TestCounter{ 5 })
+		t.AddLink("obj", "other", "one", "two")
+	}, 0)
+	db.DeleteOne("obj", "two")
+	db.Close()
+
+	// Types created in a different order must keep their tags
+	db = loge.NewLogeDB(s.factory(dir))
+	defer db.Close()
+	db.CreateType(loge.NewTypeDef("counter", 1, &TestCounter{}))
+	var def = loge.NewTypeDef("obj", 1, &TestObj{})
+	def.Links = loge.LinkSpec{ "other": "obj" }
+	db.CreateType(def)
+
+	expectName(test, db, "one", "One")
+	if db.ExistsOne("obj", "two") {
+		test.Error("Deleted object back after reopen")
+	}
+	if counter := db.ReadOne("counter", "x").(*TestCounter); counter == nil || counter.Value != 5 {
+		test.Errorf("Wrong counter after reopen: %v", counter)
+	}
+	if links := db.ReadLinksOne("obj", "other", "one"); !reflect.DeepEqual(links, []string{ "two" }) {
+		test.Errorf("Wrong links after reopen: %v", links)
+	}
+	expectKeys(test, "find after reopen", db.Find("obj", "other", "two"), "one")
+	expectKeys(test, "list after reopen", db.ListSlice("obj", "", -1), "one")
+}
+
+func (s *suite) testConcurrency(test *testing.T) {
+	var db = s.openTemp(test)
+	var writers = s.options.Writers
+	const increments = 50
+
+	db.SetOne("counter", "shared", &TestCounter{ 0 })
+
+	var group sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		group.Add(1)
+		go func(i int) {
+			defer group.Done()
+			var own = loge.LogeKey(fmt.Sprintf("writer%03d", i))
+			for j := 0; j < increments; j++ {
+				db.Transact(func (t *loge.Transaction) {
+					t.Write("counter", "shared").(*TestCounter).Value++
+					if t.Exists("counter", own) {
+						t.Write("counter", own).(*TestCounter).Value++
+					} else {
+						t.Set("counter", own, &TestCounter{ 1 })
+					}
+				}, 0)
+			}
+		}(i)
+	}
+	group.Wait()
+
+	var shared = db.ReadOne("counter", "shared").(*TestCounter)
+	if shared.Value != uint32(writers * increments) {
+		test.Errorf("Lost updates: %d of %d", shared.Value, writers * increments)
+	}
+
+	var keys = db.ListSlice("counter", "", -1)
+	if len(keys) != writers + 1 {
+		test.Errorf("Wrong key count: %d", len(keys))
+	}
+	for _, key := range keys {
+		if key == "shared" {
+			continue
+		}
+		if own := db.ReadOne("counter", key).(*TestCounter); own.Value != increments {
+			test.Errorf("Wrong count for %s: %d", key, own.Value)
+		}
+	}
+}
+
+
+// Only for stores implementing loge.SnapshotStore
+func (s *suite) testSnapshotIDs(test *testing.T) {
+	var dir = test.TempDir()
+	var db, store = s.openStore(test, dir)
+	var snapshots, ok = store.(loge.SnapshotStore)
+	if !ok {
+		db.Close()
+		test.Skip("Store doesn't keep snapshot IDs")
+	}
+
+	db.SetOne("obj", "one", &TestObj{ "One" })
+	db.SetOne("obj", "one", &TestObj{ "Two" })
+
+	var last = snapshots.LastSnapshotID()
+	var reader = db.CreateReadTransaction()
+	if last == 0 || reader.SnapshotID() != last {
+		test.Errorf("Wrong last snapshot: %d (reading at %d)", last, reader.SnapshotID())
+	}
+	reader.Close()
+	db.Close()
+
+	if s.options.Volatile {
+		return
+	}
+
+	db, store = s.openStore(test, dir)
+	defer db.Close()
+	snapshots = store.(loge.SnapshotStore)
+	if snapshots.LastSnapshotID() != last {
+		test.Errorf("Last snapshot %d after reopen, was %d", snapshots.LastSnapshotID(), last)
+	}
+
+	db.SetOne("obj", "one", &TestObj{ "Three" })
+	if snapshots.LastSnapshotID() <= last {
+		test.Errorf("Snapshot IDs went backwards: %d after %d", snapshots.LastSnapshotID(), last)
+	}
+}
+
+// Only for stores implementing loge.ChangeLogStore, with it switched on
+func (s *suite) testChangeLog(test *testing.T) {
+	var dir = test.TempDir()
+	var db, store = s.openStore(test, dir)
+	if log, ok := store.(loge.ChangeLogStore); !ok || !log.KeepsChanges() {
+		db.Close()
+		test.Skip("Store keeps no change log")
+	}
+
+	db.SetOne("obj", "one", &TestObj{ "One" })
+	db.Transact(func (t *loge.Transaction) {
+		t.Write("obj", "one").(*TestObj).Name = "Changed"
+		t.Set("obj", "two", &TestObj{ "Two" })
+		t.AddLink("obj", "other", "one", "two")
+	}, 0)
+	db.DeleteOne("obj", "two")
+
+	var changes, err = db.ChangesSince(0, -1)
+	if err != nil {
+		test.Fatalf("ChangesSince error: %v", err)
+	}
+	expectChanges(test, "all", changes, "one", "one", "two", "one", "two")
+
+	var first = changes[0]
+	if first.Old() != nil || first.New().(*TestObj).Name != "One" {
+		test.Errorf("Wrong first change: %v", first)
+	}
+	if links := changes[3]; links.LinkName != "other" ||
+		!reflect.DeepEqual(links.New(), []string{ "two" }) {
+		test.Errorf("Wrong link change: %v", links)
+	}
+	if last := changes[4]; last.Old().(*TestObj).Name != "Two" || last.New() != nil {
+		test.Errorf("Wrong delete change: %v", last)
+	}
+
+	var limited, _ = db.ChangesSince(first.SnapshotID, 1)
+	expectChanges(test, "whole commit", limited, "one", "two", "one")
+
+	var cursor = changes[len(changes) - 1].SnapshotID
+	if rest, _ := db.ChangesSince(cursor, -1); len(rest) != 0 {
+		test.Errorf("Changes after the last: %v", rest)
+	}
+	db.Close()
+
+	if s.options.Volatile {
+		return
+	}
+
+	db = s.open(test, dir)
+	defer db.Close()
+	db.SetOne("obj", "three", &TestObj{ "Three" })
+
+	changes, _ = db.ChangesSince(cursor, -1)
+	expectChanges(test, "after reopen", changes, "three")
+	if len(changes) == 1 && changes[0].SnapshotID <= cursor {
+		test.Errorf("Snapshot IDs went backwards: %d after %d", changes[0].SnapshotID, cursor)
+	}
+}
+
+
+// Reopening needs loge.SnapshotStore, so history's snapshot IDs carry on
+func (s *suite) testHistory(test *testing.T) {
+	var kept = loge.NewTypeDef("kept", 1, &TestObj{})
+	kept.KeepVersions = 3
+
+	var dir = test.TempDir()
+	var db, store = s.openStore(test, dir)
+	db.CreateType(kept)
+
+	for _, name := range []string{ "One", "Two", "Three" } {
+		db.SetOne("kept", "obj", &TestObj{ name })
+	}
+
+	if _, ok := store.(loge.SnapshotStore); ok && !s.options.Volatile {
+		db.Close()
+		db = s.open(test, dir)
+		db.CreateType(kept)
+	}
+	defer db.Close()
+	db.SetOne("kept", "obj", &TestObj{ "Four" })
+
+	var history = db.History("kept", "obj")
+	var names = make([]string, 0, len(history))
+	for i, entry := range history {
+		names = append(names, entry.Object.(*TestObj).Name)
+		if i > 0 && entry.SnapshotID <= history[i-1].SnapshotID {
+			test.Errorf("Snapshot IDs went backwards: %d after %d", entry.SnapshotID, history[i-1].SnapshotID)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{ "Two", "Three", "Four" }) {
+		test.Errorf("Wrong history: %v", names)
+	}
+
+	if len(history) == 3 {
+		if obj, ok := db.ReadAt("kept", "obj", history[1].SnapshotID); !ok || obj.(*TestObj).Name != "Three" {
+			test.Errorf("Wrong object at snapshot: %v, %v", obj, ok)
+		}
+		if _, ok := db.ReadAt("kept", "obj", history[0].SnapshotID - 1); ok {
+			test.Error("Read before retained history")
+		}
+	}
+}
+
+
+// -----------------------------------------------
+// Helpers
+// -----------------------------------------------
+
+func expectName(test *testing.T, db *loge.LogeDB, key loge.LogeKey, name string) {
+	test.Helper()
+	var obj = db.ReadOne("obj", key).(*TestObj)
+	if obj == nil {
+		test.Errorf("Object %s missing", key)
+	} else if obj.Name != name {
+		test.Errorf("Wrong name for %s: %s (expected %s)", key, obj.Name, name)
+	}
+}
+
+func expectKeys(test *testing.T, label string, keys []loge.LogeKey, expected ...loge.LogeKey) {
+	test.Helper()
+	if len(keys) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		test.Errorf("Wrong keys (%s): %v (expected %v)", label, keys, expected)
+	}
+}
+
+func expectChanges(test *testing.T, label string, changes []loge.ChangeEvent, keys ...loge.LogeKey) {
+	test.Helper()
+	var got = make([]loge.LogeKey, 0, len(changes))
+	for i, change := range changes {
+		got = append(got, change.Key)
+		if i > 0 && change.SnapshotID < changes[i-1].SnapshotID {
+			test.Errorf("Changes out of order (%s): %d after %d", label, change.SnapshotID, changes[i-1].SnapshotID)
+		}
+	}
+	expectKeys(test, label, got, keys...)
+}
@@ -0,0 +1,257 @@
+package loge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type TraceEventType int
+
+const (
+	TRACE_READ TraceEventType = iota
+	TRACE_WRITE
+	TRACE_LOCK_FAILED
+	TRACE_ABORT
+	TRACE_ERROR
+	TRACE_RETRY
+	TRACE_CANCEL
+	TRACE_COMMIT
+)
+
+type TraceEvent struct {
+	Type TraceEventType
+	TransactionID uint64
+	SnapshotID uint64
+	Attempt int
+	Time time.Time
+
+	TypeName string
+	Key LogeKey
+	LinkName string
+
+	// Set on TRACE_ABORT and TRACE_ERROR
+	Reason string
+	// Set on TRACE_COMMIT
+	State TransactionState
+	Latency time.Duration
+	Elapsed time.Duration
+}
+
+type Tracer interface {
+	TraceEvent(event TraceEvent)
+}
+
+// Setup only, as SetMetrics; DBOptions.Tracer is the safer way
+func (db *LogeDB) SetTracer(tracer Tracer) {
+	db.tracer = tracer
+}
+
+func (event TraceEvent) ObjectName() string {
+	if event.LinkName != "" {
+		return fmt.Sprintf("%s.%s:%s", event.TypeName, event.LinkName, event.Key)
+	}
+	return fmt.Sprintf("%s:%s", event.TypeName, event.Key)
+}
+
+func (t *Transaction) trace(eventType TraceEventType, ref *ObjRef, reason string) {
+	if t.db.tracer == nil {
+		return
+	}
+
+	var event = t.newTraceEvent(eventType)
+	event.Reason = reason
+
+	if ref != nil {
+		event.TypeName = ref.Type.Name
+		event.Key = ref.Key
+		event.LinkName = ref.LinkName
+	}
+
+	t.db.tracer.TraceEvent(event)
+}
+
+func (t *Transaction) traceObject(eventType TraceEventType, obj *logeObject, reason string) {
+	if t.db.tracer == nil {
+		return
+	}
+	var ref = obj.makeObjRef()
+	t.trace(eventType, &ref, reason)
+}
+
+func (t *Transaction) traceCommit(latency time.Duration) {
+	if t.db.tracer == nil {
+		return
+	}
+
+	var event = t.newTraceEvent(TRACE_COMMIT)
+	event.Latency = latency
+	t.db.tracer.TraceEvent(event)
+}
+
+func (t *Transaction) newTraceEvent(eventType TraceEventType) TraceEvent {
+	var now = time.Now()
+	return TraceEvent{
+		Type: eventType,
+		TransactionID: t.id,
+		SnapshotID: t.snapshotID,
+		Attempt: t.attempt,
+		Time: now,
+		State: t.state,
+		Elapsed: now.Sub(t.started),
+	}
+}
+
+func (ts TraceEventType) String() string {
+	switch ts {
+	case TRACE_READ:
+		return "READ"
+	case TRACE_WRITE:
+		return "WRITE"
+	case TRACE_LOCK_FAILED:
+		return "LOCK_FAILED"
+	case TRACE_ABORT:
+		return "ABORT"
+	case TRACE_ERROR:
+		return "ERROR"
+	case TRACE_RETRY:
+		return "RETRY"
+	case TRACE_CANCEL:
+		return "CANCEL"
+	case TRACE_COMMIT:
+		return "COMMIT"
+	}
+	return "UNKNOWN EVENT"
+}
+
+
+// -----------------------------------------------
+// Slow transaction log
+// -----------------------------------------------
+
+type SlowTransaction struct {
+	TransactionID uint64
+	SnapshotID uint64
+	Attempt int
+	State string
+	Elapsed time.Duration
+	CommitLatency time.Duration
+	Reads []string
+	Writes []string
+	LockFailures []string
+	AbortReason string `json:",omitempty"`
+	ConflictKey string `json:",omitempty"`
+}
+
+// Transactions which are never finished are dropped from the log, and
+// emitted as ABANDONED if slow, once this many newer ones are pending
+const slow_MAX_PENDING = 1000
+
+type SlowLog struct {
+	Threshold time.Duration
+	MaxPending int
+	lock sync.Mutex
+	pending map[uint64]*SlowTransaction
+	// Pending IDs, oldest first, including some already finished
+	order []uint64
+	emit func(*SlowTransaction)
+}
+
+// Tracer which collects events per transaction, and passes a summary to
+// emit for any transaction taking longer than threshold to finish.
+func NewSlowLog(threshold time.Duration, emit func(*SlowTransaction)) *SlowLog {
+	return &SlowLog{
+		Threshold: threshold,
+		MaxPending: slow_MAX_PENDING,
+		pending: make(map[uint64]*SlowTransaction),
+		emit: emit,
+	}
+}
+
+// Slow log writing one JSON record per line
+func NewJSONSlowLog(threshold time.Duration, w io.Writer) *SlowLog {
+	var lock sync.Mutex
+	var encoder = json.NewEncoder(w)
+	return NewSlowLog(threshold, func(record *SlowTransaction) {
+		lock.Lock()
+		defer lock.Unlock()
+		encoder.Encode(record)
+	})
+}
+
+func (log *SlowLog) TraceEvent(event TraceEvent) {
+	log.lock.Lock()
+
+	var done []*SlowTransaction
+	var record, ok = log.pending[event.TransactionID]
+	if !ok {
+		record = &SlowTransaction{
+			TransactionID: event.TransactionID,
+			SnapshotID: event.SnapshotID,
+			Attempt: event.Attempt,
+		}
+		log.pending[event.TransactionID] = record
+		log.order = append(log.order, event.TransactionID)
+		done = log.dropAbandoned()
+	}
+	record.Elapsed = event.Elapsed
+
+	var name = event.ObjectName()
+
+	switch event.Type {
+	case TRACE_READ:
+		record.Reads = append(record.Reads, name)
+	case TRACE_WRITE:
+		record.Writes = append(record.Writes, name)
+	case TRACE_LOCK_FAILED:
+		record.LockFailures = append(record.LockFailures, name)
+	case TRACE_ABORT, TRACE_ERROR:
+		record.AbortReason = event.Reason
+		if event.TypeName != "" {
+			record.ConflictKey = name
+		}
+	case TRACE_CANCEL, TRACE_COMMIT:
+		delete(log.pending, event.TransactionID)
+		record.State = event.State.String()
+		record.Elapsed = event.Elapsed
+		record.CommitLatency = event.Latency
+		done = append(done, record)
+	}
+
+	log.lock.Unlock()
+
+	for _, record := range done {
+		if record.Elapsed >= log.Threshold {
+			log.emit(record)
+		}
+	}
+}
+
+// Called with the lock held. Returns the dropped records, marked
+// ABANDONED.
+func (log *SlowLog) dropAbandoned() []*SlowTransaction {
+	var dropped []*SlowTransaction
+	for log.MaxPending > 0 && len(log.pending) > log.MaxPending && len(log.order) > 0 {
+		var record, ok = log.pending[log.order[0]]
+		log.order = log.order[1:]
+		if ok {
+			delete(log.pending, record.TransactionID)
+			record.State = "ABANDONED"
+			dropped = append(dropped, record)
+		}
+	}
+
+	// Finished IDs build up behind long-running ones
+	if log.MaxPending > 0 && len(log.order) > 2 * log.MaxPending + 1 {
+		var order = make([]uint64, 0, len(log.pending))
+		for _, tID := range log.order {
+			if _, ok := log.pending[tID]; ok {
+				order = append(order, tID)
+			}
+		}
+		log.order = order
+	}
+	return dropped
+}
@@ -0,0 +1,149 @@
+package loge
+
+import (
+	"testing"
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type recordingTracer struct {
+	lock sync.Mutex
+	events []TraceEvent
+}
+
+func (tracer *recordingTracer) TraceEvent(event TraceEvent) {
+	tracer.lock.Lock()
+	defer tracer.lock.Unlock()
+	tracer.events = append(tracer.events, event)
+}
+
+func (tracer *recordingTracer) ofType(eventType TraceEventType) []TraceEvent {
+	var found []TraceEvent
+	for _, event := range tracer.events {
+		if event.Type == eventType {
+			found = append(found, event)
+		}
+	}
+	return found
+}
+
+func TestTraceAbort(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var tracer = &recordingTracer{}
+	db.SetTracer(tracer)
+
+	var trans1 = db.CreateTransaction()
+	var trans2 = db.CreateTransaction()
+
+	trans1.Read("test", "one")
+	trans1.Set("test", "two", &TestObj{Name: "Two"})
+	trans2.Write("test", "one").(*TestObj).Name = "Updated"
+
+	trans2.Commit()
+	trans1.Commit()
+
+	var reads = tracer.ofType(TRACE_READ)
+	if len(reads) != 1 || reads[0].ObjectName() != "test:one" || reads[0].TransactionID != trans1.id {
+		test.Errorf("Wrong read events: %v", reads)
+	}
+
+	if len(tracer.ofType(TRACE_WRITE)) != 2 {
+		test.Errorf("Wrong write events: %v", tracer.ofType(TRACE_WRITE))
+	}
+
+	var aborts = tracer.ofType(TRACE_ABORT)
+	if len(aborts) != 1 || aborts[0].TransactionID != trans1.id || aborts[0].Key != "one" {
+		test.Errorf("Wrong abort events: %v", aborts)
+	}
+
+	var commits = tracer.ofType(TRACE_COMMIT)
+	if len(commits) != 2 || commits[0].State != FINISHED || commits[1].State != ABORTED {
+		test.Errorf("Wrong commit events: %v", commits)
+	}
+}
+
+func TestSlowLog(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+	db.SetOne("test", "one", &TestObj{Name: "One"})
+
+	var buf bytes.Buffer
+	db.SetTracer(NewJSONSlowLog(0, &buf))
+
+	var trans1 = db.CreateTransaction()
+	var trans2 = db.CreateTransaction()
+	trans1.Write("test", "one")
+	trans2.Write("test", "one")
+	trans1.Commit()
+	trans2.Commit()
+
+	var decoder = json.NewDecoder(&buf)
+	var records []SlowTransaction
+	for decoder.More() {
+		var record SlowTransaction
+		if err := decoder.Decode(&record); err != nil {
+			test.Fatalf("Bad slow log record: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 2 {
+		test.Fatalf("Wrong slow log record count: %d", len(records))
+	}
+
+	if records[1].State != "ABORTED" || records[1].ConflictKey != "test:one" {
+		test.Errorf("Wrong aborted record: %+v", records[1])
+	}
+
+	db.SetTracer(NewJSONSlowLog(time.Hour, &buf))
+	db.SetOne("test", "one", &TestObj{Name: "Fast"})
+	if buf.Len() != 0 {
+		test.Errorf("Fast transaction logged: %s", buf.String())
+	}
+}
+
+func TestSlowLogAbandoned(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("test", 1, &TestObj{}))
+
+	var records []*SlowTransaction
+	var slowLog = NewSlowLog(0, func(record *SlowTransaction) {
+		records = append(records, record)
+	})
+	slowLog.MaxPending = 1
+	db.SetTracer(slowLog)
+
+	var abandoned = db.CreateTransaction()
+	abandoned.Read("test", "one")
+
+	func() {
+		defer func() {
+			recover()
+		}()
+		db.Transact(func (t *Transaction) {
+			t.Read("test", "one")
+			panic("Actor failed")
+		}, 0)
+	}()
+
+	for _, name := range []string{ "One", "Two", "Three" } {
+		db.SetOne("test", "one", &TestObj{ name })
+	}
+
+	if len(records) != 5 || records[1].State != "CANCELLED" || records[4].State != "FINISHED" {
+		test.Fatalf("Wrong slow log record count: %d", len(records))
+	}
+	if records[0].TransactionID != abandoned.id || records[0].State != "ABANDONED" ||
+		len(records[0].Reads) != 1 {
+		test.Errorf("Wrong abandoned record: %+v", records[0])
+	}
+	if len(slowLog.pending) != 0 {
+		test.Errorf("Records left pending: %v", slowLog.pending)
+	}
+	abandoned.Cancel()
+}
@@ -3,7 +3,8 @@ package loge
 import (
 	"fmt"
 	"time"
-	"math/rand"
+	"sort"
+	"sync/atomic"
 )
 
 type TransactionState int
@@ -15,6 +16,7 @@ const (
 	FINISHED
 	ABORTED
 	ERROR
+	INVALID
 )
 
 
@@ -22,26 +24,45 @@ type liveVersion struct {
 	version *objectVersion
 	object interface{}
 	dirty bool
+	// What the version held when acquired
+	blob []byte
+	loaded bool
+
+	// Set while only commutative operations have touched the object
+	merge bool
+	increments map[string]int64
+	linkDeltas map[string]bool
 }
 
 
 type Transaction struct {
 	db *LogeDB
-	context transactionContext
+	context TransactionContext
 	versions map[string]*liveVersion
+	scans []scanRange
+	onCommit []func()
 	state TransactionState
 	snapshotID uint64
 	cancelled bool
 	giveJSON bool
+	validating bool
+	err error
+
+	id uint64
+	attempt int
+	started time.Time
 }
 
 func NewTransaction(db *LogeDB, sID uint64) *Transaction {
 	return &Transaction{
 		db: db,
-		context: db.store.newContext(sID),
+		context: db.store.NewContext(sID),
 		versions: make(map[string]*liveVersion),
 		state: ACTIVE,
 		snapshotID: sID,
+		id: atomic.AddUint64(&db.lastTransactionID, 1),
+		attempt: 1,
+		started: time.Now(),
 	}
 }
 
@@ -54,30 +75,37 @@ func (t *Transaction) GetState() TransactionState {
 	return t.state
 }
 
+// Why the commit failed, for INVALID and ERROR
+func (t *Transaction) Err() error {
+	return t.err
+}
+
 func (t *Transaction) Exists(typeName string, key LogeKey) bool {
-	var lv = t.getVersion(t.db.makeObjRef(typeName, key), false, true)
+	var lv = t.getVersion(t.db.makeObjRef(typeName, key), false, true, false)
 	return lv.version.LogeObj.hasValue(lv.object)
 }
 
 
+// A copy, so changes to it never reach the transaction
 func (t *Transaction) Read(typeName string, key LogeKey) interface{} {
-	return t.getVersion(t.db.makeObjRef(typeName, key), false, true).object
+	return deepCopy(t.getVersion(t.db.makeObjRef(typeName, key), false, true, false).object)
 }
 
 
+// The transaction's own copy, committed with any changes made to it
 func (t *Transaction) Write(typeName string, key LogeKey) interface{} {
-	return t.getVersion(t.db.makeObjRef(typeName, key), true, true).object
+	return t.getVersion(t.db.makeObjRef(typeName, key), true, true, false).object
 }
 
 
 func (t *Transaction) Set(typeName string, key LogeKey, obj interface{}) {
-	var version = t.getVersion(t.db.makeObjRef(typeName, key), true, false)
+	var version = t.getVersion(t.db.makeObjRef(typeName, key), true, false, false)
 	version.object = obj
 }
 
 
 func (t *Transaction) Delete(typeName string, key LogeKey) {
-	var version = t.getVersion(t.db.makeObjRef(typeName, key), true, true)
+	var version = t.getVersion(t.db.makeObjRef(typeName, key), true, true, false)
 	version.object = version.version.LogeObj.Type.NilValue()
 }
 
@@ -91,11 +119,11 @@ func (t *Transaction) HasLink(typeName string, linkName string, key LogeKey, tar
 }
 
 func (t *Transaction) AddLink(typeName string, linkName string, key LogeKey, target LogeKey) {
-	t.getLink(t.db.makeLinkRef(typeName, linkName, key), true, true).Add(string(target))
+	t.linkDelta(t.db.makeLinkRef(typeName, linkName, key), target, true)
 }
 
 func (t *Transaction) RemoveLink(typeName string, linkName string, key LogeKey, target LogeKey) {
-	t.getLink(t.db.makeLinkRef(typeName, linkName, key), true, true).Remove(string(target))
+	t.linkDelta(t.db.makeLinkRef(typeName, linkName, key), target, false)
 }
 
 func (t *Transaction) SetLinks(typeName string, linkName string, key LogeKey, targets []LogeKey) {
@@ -108,11 +136,15 @@ func (t *Transaction) SetLinks(typeName string, linkName string, key LogeKey, ta
 }
 
 func (t *Transaction) Find(typeName string, linkName string, target LogeKey) ResultSet {
-	return t.context.find(t.db.makeLinkRef(typeName, linkName, target))
+	var ref = t.db.makeLinkRef(typeName, linkName, target)
+	t.recordIndexScan(ref, "")
+	return t.context.Find(ref)
 }
 
 func (t *Transaction) FindSlice(typeName string, linkName string, target LogeKey, from LogeKey, limit int) ResultSet {	
-	return t.context.findSlice(t.db.makeLinkRef(typeName, linkName, target), from, limit)
+	var ref = t.db.makeLinkRef(typeName, linkName, target)
+	t.recordIndexScan(ref, from)
+	return t.context.FindSlice(ref, from, limit)
 }
 
 func (t *Transaction) ListSlice(typeName string, from LogeKey, limit int) ResultSet {	
@@ -121,21 +153,24 @@ func (t *Transaction) ListSlice(typeName string, from LogeKey, limit int) Result
 		panic(fmt.Sprintf("No such type %s\n", typeName))
 	}
 	var prefix = typePrefix(typ)
-	return t.context.listSlice(prefix, from, limit)
+	t.recordScan(prefix, from)
+	return t.context.ListSlice(prefix, from, limit)
 }
 
 // -----------------------------------------------
 // Internals
 // -----------------------------------------------
 
-func (t *Transaction) getLink(ref objRef, forWrite bool, load bool) *linkSet {
-	var version = t.getVersion(ref, forWrite, load)
+func (t *Transaction) getLink(ref ObjRef, forWrite bool, load bool) *linkSet {
+	var version = t.getVersion(ref, forWrite, load, false)
 	return version.object.(*linkSet)
 }
 
-func (t *Transaction) getVersion(ref objRef, forWrite bool, load bool) *liveVersion {
+// Merge marks commutative operations, which leave the object free to
+// change under the transaction.
+func (t *Transaction) getVersion(ref ObjRef, forWrite bool, load bool, merge bool) *liveVersion {
 
-	if t.state != ACTIVE {
+	if t.state != ACTIVE && !(t.validating && !forWrite) {
 		panic(fmt.Sprintf("GetObj from inactive transaction %s\n", t))
 	}
 
@@ -144,35 +179,40 @@ func (t *Transaction) getVersion(ref objRef, forWrite bool, load bool) *liveVers
 	lv, ok := t.versions[objKey]
 
 	if ok {
-		if forWrite {
+		lv.merge = lv.merge && merge
+		if forWrite && !lv.dirty {
 			lv.dirty = true
+			t.trace(TRACE_WRITE, &ref, "")
 		}
 		return lv
 	}
 
-	var version = t.db.acquireVersion(ref, t.context, load)
+	lv = t.db.acquireVersion(ref, t.context, load)
 
-	object, upgraded := version.getObject(t.giveJSON)
+	var upgraded = t.decodeVersion(lv)
+	lv.dirty = forWrite || upgraded
+	lv.merge = merge
 
-	lv = &liveVersion{
-		version: version,
-		object: object,
-		dirty: forWrite || upgraded,
+	t.versions[objKey] = lv
+
+	if forWrite {
+		t.trace(TRACE_WRITE, &ref, "")
+	} else {
+		t.trace(TRACE_READ, &ref, "")
 	}
 
-	t.versions[objKey] = lv
 	return lv
 }
 
 
-const t_BACKOFF_EXPONENT = 1.05
-
 func (t *Transaction) Cancel() {
 	if (t.state != ACTIVE) {
 		panic(fmt.Sprintf("Cancel on transaction %s\n", t))
 	}
 
 	t.state = CANCELLED
+	t.finish(t.liveVersions())
+	t.trace(TRACE_CANCEL, nil, "")
 }
 
 func (t *Transaction) Commit() bool {
@@ -184,61 +224,213 @@ func (t *Transaction) Commit() bool {
 		panic(fmt.Sprintf("Commit on transaction %s\n", t))
 	}
 
+	if t.db.expiring {
+		t.stampExpiries()
+	}
+
 	t.state = COMMITTING
 
-	var versions = make([]*liveVersion, 0, len(t.versions))
-	for _, v := range t.versions {
-		versions = append(versions, v)
+	var start = time.Now()
+
+	var versions = t.tryCommit()
+	t.finish(versions)
+
+	if t.state == FINISHED {
+		for _, callback := range t.onCommit {
+			callback()
+		}
 	}
-	
-	var delayFact = 10.0
-	for {
-		if t.tryCommit(versions) {
-			break
+
+	var metrics = t.db.metrics
+	switch t.state {
+	case FINISHED:
+		metrics.Count(metric_COMMITS, 1)
+	case ABORTED:
+		metrics.Count(metric_ABORTS, 1)
+	case ERROR:
+		metrics.Count(metric_COMMIT_ERRORS, 1)
+	case INVALID:
+		metrics.Count(metric_VALIDATION_FAILURES, 1)
+	}
+	metrics.Observe(metric_COMMIT_SECONDS, time.Since(start).Seconds())
+
+	t.traceCommit(time.Since(start))
+
+	return t.state == FINISHED
+}
+
+// A panicking actor leaves the transaction cancelled, so it doesn't
+// hold its snapshot
+func (t *Transaction) run(actor Transactor) {
+	var returned = false
+	defer func() {
+		if !returned && t.state == ACTIVE {
+			t.Cancel()
 		}
-		var delay = time.Duration(delayFact - float64(rand.Intn(10)))
-		time.Sleep(delay * time.Millisecond)
-		delayFact *= t_BACKOFF_EXPONENT
+	}()
+	actor(t)
+	returned = true
+}
+
+// Sorted by cache key, which is the order commits lock objects in
+func (t *Transaction) liveVersions() []*liveVersion {
+	var keys = make([]string, 0, len(t.versions))
+	for key := range t.versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var versions = make([]*liveVersion, 0, len(t.versions))
+	for _, key := range keys {
+		versions = append(versions, t.versions[key])
+	}
+	return versions
+}
+
+func (t *Transaction) finish(versions []*liveVersion) {
+	if t.state == ABORTED || t.state == CANCELLED || t.state == INVALID {
+		t.context.Rollback()
 	}
 
 	t.db.releaseVersions(versions)
+	t.db.endSnapshot(t.snapshotID)
 
-	return t.state == FINISHED
+	if t.state == FINISHED {
+		t.db.countCommit()
+	}
 }
 
-func (t *Transaction) tryCommit(versions []*liveVersion) bool {
+// Locks are taken in key order, so commits sharing objects queue up
+// rather than deadlock.
+func (t *Transaction) tryCommit() (versions []*liveVersion) {
+	// Validators may read more objects, which are locked and checked too
+	var valid = t.validate()
+	versions = t.liveVersions()
+	if !valid {
+		return
+	}
+
+	// Deferred first, so events go out once the locks are released.
+	// The feed puts commits back in order.
+	var sID uint64
+	var events []ChangeEvent
+	defer func() {
+		if sID != 0 {
+			t.db.changes.publish(sID, events)
+		}
+	}()
+
+	var waited time.Duration
 	for _, lv := range versions {
 		var obj = lv.version.LogeObj
 
 		if !obj.Lock.TryLock() {
-			return false
+			t.traceObject(TRACE_LOCK_FAILED, obj, "")
+			var start = time.Now()
+			obj.Lock.Lock()
+			waited += time.Since(start)
 		}
 		defer obj.Lock.Unlock()
+	}
+
+	if waited > 0 {
+		t.db.metrics.Observe(metric_LOCK_WAIT_SECONDS, waited.Seconds())
+	}
+
+	for _, lv := range versions {
+		var obj = lv.version.LogeObj
+
+		if lv.merge {
+			t.rebase(lv)
+			continue
+		}
 
-		if obj.Current.snapshotID > t.snapshotID {
+		if updated := obj.currentSnapshotID(); updated > t.snapshotID {
 			t.state = ABORTED
-			return true
+			t.db.logger.Debug("Transaction aborted",
+				"transaction", t.id, "type", obj.Type.Name, "key", obj.Key,
+				"snapshot", t.snapshotID, "updated", updated)
+			t.traceObject(TRACE_ABORT, obj, 
+				fmt.Sprintf("Updated at snapshot %d", updated))
+			return
 		}
 	}
 
 	var context = t.context
-	var sID = t.db.newSnapshotID()
+
+	// Checking scans and taking a snapshot ID together means every
+	// commit either sees this one's record, or is seen by it
+	if t.db.serializable {
+		t.db.scanLock.Lock()
+		defer t.db.scanLock.Unlock()
+
+		if key, conflict := t.db.scanConflict(t.snapshotID, t.scans); conflict {
+			t.state = ABORTED
+			t.db.logger.Debug("Transaction aborted",
+				"transaction", t.id, "snapshot", t.snapshotID, "scanned", fmt.Sprintf("%q", key))
+			t.trace(TRACE_ABORT, nil, "Scanned range changed")
+			return
+		}
+	}
+
+	sID = t.db.beginCommit()
+	defer t.db.endCommit(sID)
+
+	if t.db.serializable {
+		t.db.recordCommit(sID, t.touchedKeys(versions))
+	}
+	var active = t.db.activeSnapshots()
+
+	var watched = t.db.changes.watched()
+	var log, logging = context.(ChangeLogContext)
+	logging = logging && t.db.changeLog != nil
+	var now = time.Now()
 
 	for _, lv := range versions {
 		if lv.dirty {
 			var obj = lv.version.LogeObj
-			obj.applyVersion(lv.object, context, sID)
+			var event ChangeEvent
+			if watched || logging {
+				event = t.changeEvent(lv, sID)
+			}
+
+			var blob = obj.applyVersion(lv.object, context, sID, active)
+			if len(blob) > 0 {
+				event.NewBlob = blob
+			}
+			if obj.LinkName == "" && obj.Type.KeepVersions > 0 {
+				t.recordHistory(obj, blob, sID, now)
+			}
+
+			if watched {
+				events = append(events, event)
+			}
+			if logging {
+				log.LogChange(event)
+			}
 		}
 	}
 
-	var err = context.commit(sID)
+	var err = context.Commit(sID)
 	if err != nil {
+		events = nil
 		t.state = ERROR
-		fmt.Printf("Commit error: %v\n", err)
+		t.err = err
+		t.db.logger.Error("Commit error", "error", err, "snapshot", sID)
+		t.trace(TRACE_ERROR, nil, err.Error())
+		for _, lv := range versions {
+			if lv.dirty {
+				var obj = lv.version.LogeObj
+				obj.shard.lock.Lock()
+				obj.invalid = true
+				obj.shard.lock.Unlock()
+			}
+		}
+		return
 	}
 
 	t.state = FINISHED
-	return true
+	return
 }
 
 
@@ -256,6 +448,8 @@ func (ts TransactionState) String() string {
 		return "ABORTED"
 	case ERROR: 
 		return "ERROR"
+	case INVALID:
+		return "INVALID"
 	}
 	return "UNKNOWN STATE"
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ package loge
 import (
 	"reflect"
 	"fmt"
+	"time"
 
 	"github.com/brendonh/spack"
 )
@@ -13,8 +14,25 @@ type TypeDef struct {
 	Exemplar interface{}
 	Links LinkSpec
 	Upgrader spack.UpgradeFunc
+	CachePolicy CachePolicy
+	Validator Validator
+	// Objects expire this long after they last changed, unless set with
+	// SetWithTTL. Zero is never.
+	TTL time.Duration
+	// Allows SetWithTTL without a default TTL
+	Expires bool
+	// Versions kept in the store for History and ReadAt, counting the
+	// latest. Zero keeps none. Stores kept across restarts need to be
+	// SnapshotStores, or ReadAt can mix up versions from before.
+	KeepVersions int
 }
 
+// Checks each object of the type a transaction changes, before it
+// commits. Deletions aren't validated. The transaction can be read from
+// (at its snapshot) but not written. Increments and link deltas on the
+// type aren't merged, but conflict-checked like any other write.
+type Validator func(t *Transaction, key LogeKey, obj interface{}) error
+
 func NewTypeDef(name string, version uint16, exemplar interface{}) *TypeDef {
 	return &TypeDef {
 		Name: name,
@@ -26,27 +44,31 @@ func NewTypeDef(name string, version uint16, exemplar interface{}) *TypeDef {
 // -----------------------
 
 var linkSpec *spack.TypeSpec = spack.MakeTypeSpec([]string{})
-var linkInfoSpec *spack.TypeSpec = spack.MakeTypeSpec(linkInfo{})
+var linkInfoSpec *spack.TypeSpec = spack.MakeTypeSpec(LinkInfo{})
 
-type logeType struct {
+type LogeType struct {
 	Name string
 	Version uint16
 	Exemplar interface{}
 	SpackType *spack.VersionedType
-	Links map[string]*linkInfo
+	Links map[string]*LinkInfo
+	CachePolicy CachePolicy
+	Validator Validator
+	TTL time.Duration
+	Expires bool
+	KeepVersions int
 }
 
-func newType(name string, version uint16, exemplar interface{}, linkSpec LinkSpec, spackType *spack.VersionedType) *logeType {
-	var infos = make(map[string]*linkInfo)
+func newType(name string, version uint16, exemplar interface{}, linkSpec LinkSpec, spackType *spack.VersionedType) *LogeType {
+	var infos = make(map[string]*LinkInfo)
 	for k, v := range linkSpec {
-		infos[k] = &linkInfo{
+		infos[k] = &LinkInfo{
 			Name: k,
 			Target: v,
-			Tag: 1,
 		}
 	}
 
-	return &logeType {
+	return &LogeType {
 		Name: name,
 		Version: version,
 		Exemplar: exemplar,
@@ -55,11 +77,11 @@ func newType(name string, version uint16, exemplar interface{}, linkSpec LinkSpe
 	}
 }
 
-func (t *logeType) NilValue() interface{} {
+func (t *LogeType) NilValue() interface{} {
 	return reflect.Zero(reflect.TypeOf(t.Exemplar)).Interface()
 }
 
-func (t *logeType) Decode(enc []byte, toJSON bool) (interface{}, bool) {
+func (t *LogeType) Decode(enc []byte, toJSON bool) (interface{}, bool) {
 	if len(enc) == 0 {
 		if toJSON {
 			return nil, false
@@ -76,7 +98,7 @@ func (t *logeType) Decode(enc []byte, toJSON bool) (interface{}, bool) {
 	return obj, upgraded
 }
 
-func (t *logeType) Encode(obj interface{}) []byte {
+func (t *LogeType) Encode(obj interface{}) []byte {
 	enc, err := t.SpackType.EncodeObj(obj)
 	if err != nil {
 		panic(fmt.Sprintf("Encode error: %v", err))
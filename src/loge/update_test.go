@@ -54,6 +54,7 @@ func TestReadScoping(test *testing.T) {
 	if trans2.Read("test", "one").(*TestObj) != nil {
 		test.Errorf("Version visible in transaction created before obj create")
 	}
+	trans2.Cancel()
 
 	// Now on existing object
 
@@ -66,6 +67,7 @@ func TestReadScoping(test *testing.T) {
 	if trans4.Read("test", "one").(*TestObj).Name != "One" {
 		test.Errorf("Version visible in transaction created before update")
 	}
+	trans4.Cancel()
 
 	if db.ReadOne("test", "one").(*TestObj).Name != "Two" {
 		test.Errorf("One-shot read got wrong version")
@@ -0,0 +1,72 @@
+package loge
+
+import (
+	"fmt"
+)
+
+// A Validator's error, and the object it rejected
+type ValidationError struct {
+	TypeName string
+	Key LogeKey
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid %s:%s: %v", e.TypeName, e.Key, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// -----------------------------------------------
+// Internals
+// -----------------------------------------------
+
+// Runs before anything is locked, so reads made by validators go
+// through the usual conflict checks. Changed links are checked with
+// their object, which is read in if the transaction hasn't already.
+func (t *Transaction) validate() bool {
+	var checked = make(map[*logeObject]bool)
+	for _, lv := range t.liveVersions() {
+		var validator = lv.version.LogeObj.Type.Validator
+		if !lv.dirty || validator == nil {
+			continue
+		}
+
+		if link := lv.version.LogeObj; link.LinkName != "" {
+			t.validating = true
+			lv = t.getVersion(t.db.makeObjRef(link.Type.Name, link.Key), false, true, false)
+			t.validating = false
+		}
+
+		var obj = lv.version.LogeObj
+		if checked[obj] || !obj.hasValue(lv.object) {
+			continue
+		}
+		checked[obj] = true
+
+		var err = t.runValidator(validator, obj.Key, lv.object)
+		if err != nil {
+			t.state = INVALID
+			t.err = &ValidationError{ obj.Type.Name, obj.Key, err }
+			t.db.logger.Debug("Transaction invalid",
+				"transaction", t.id, "type", obj.Type.Name, "key", obj.Key, "error", err)
+			t.traceObject(TRACE_ABORT, obj, t.err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Transaction) runValidator(validator Validator, key LogeKey, object interface{}) (err error) {
+	t.validating = true
+	defer func() {
+		t.validating = false
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Validator panicked: %v", r)
+		}
+	}()
+
+	return validator(t, key, object)
+}
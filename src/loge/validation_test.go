@@ -0,0 +1,160 @@
+package loge
+
+import (
+	"testing"
+	"errors"
+)
+
+func TestValidator(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var calls = 0
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Validator = func(t *Transaction, key LogeKey, obj interface{}) error {
+		calls++
+		if obj.(*TestObj).Name == "" {
+			return errors.New("Name required")
+		}
+		return nil
+	}
+	db.CreateType(def)
+
+	db.SetOne("test", "one", &TestObj{ "One" })
+
+	var failed *Transaction
+	var ok = db.Transact(func (t *Transaction) {
+		failed = t
+		t.Set("test", "two", &TestObj{ "Two" })
+		t.Write("test", "one").(*TestObj).Name = ""
+	}, 0)
+
+	if ok || failed.GetState() != INVALID || failed.attempt != 1 {
+		test.Errorf("Invalid commit: %v, %s, attempt %d", ok, failed.GetState(), failed.attempt)
+	}
+	var invalid *ValidationError
+	if !errors.As(failed.Err(), &invalid) || invalid.Key != "one" || invalid.Err.Error() != "Name required" {
+		test.Errorf("Wrong error: %v", failed.Err())
+	}
+	if db.ExistsOne("test", "two") || db.ReadOne("test", "one").(*TestObj).Name != "One" {
+		test.Error("Invalid transaction committed")
+	}
+
+	calls = 0
+	db.Transact(func (t *Transaction) {
+		t.Read("test", "one")
+		t.Delete("test", "two")
+	}, 0)
+	if calls != 0 {
+		test.Errorf("Validator ran on unchanged or deleted objects: %d", calls)
+	}
+}
+
+func TestValidatorReads(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	db.CreateType(NewTypeDef("person", 1, &TestObj{}))
+	var def = NewTypeDef("pet", 1, &TestObj{})
+	def.Links = LinkSpec{ "owner": "person" }
+	def.Validator = func(t *Transaction, key LogeKey, obj interface{}) error {
+		var owners = t.ReadLinks("pet", "owner", key)
+		if len(owners) == 0 || !t.Exists("person", LogeKey(owners[0])) {
+			return errors.New("Pet needs an owner")
+		}
+		return nil
+	}
+	db.CreateType(def)
+
+	db.SetOne("person", "bob", &TestObj{ "Bob" })
+
+	db.SetOne("pet", "stray", &TestObj{ "Stray" })
+	if db.ExistsOne("pet", "stray") {
+		test.Error("Pet without owner committed")
+	}
+
+	var ok = db.Transact(func (t *Transaction) {
+		t.Set("pet", "rex", &TestObj{ "Rex" })
+		t.AddLink("pet", "owner", "rex", "bob")
+	}, 0)
+	if !ok {
+		test.Fatal("Valid pet failed")
+	}
+
+	// The validator's read of bob conflicts with his deletion
+	var t1 = db.CreateTransaction()
+	t1.Set("pet", "fido", &TestObj{ "Fido" })
+	t1.AddLink("pet", "owner", "fido", "bob")
+	db.DeleteOne("person", "bob")
+	if t1.Commit() || t1.GetState() != ABORTED {
+		test.Errorf("Validator read didn't conflict: %s", t1.GetState())
+	}
+
+	var t2 = db.CreateTransaction()
+	t2.Write("pet", "rex").(*TestObj).Name = "Rex II"
+	if t2.Commit() || t2.GetState() != INVALID {
+		test.Errorf("Pet of deleted owner committed: %s", t2.GetState())
+	}
+
+	// Changing links alone still validates the pet
+	db.SetOne("person", "alice", &TestObj{ "Alice" })
+	var t3 = db.CreateTransaction()
+	t3.RemoveLink("pet", "owner", "rex", "bob")
+	if t3.Commit() || t3.GetState() != INVALID {
+		test.Errorf("Removing the owner link committed: %s", t3.GetState())
+	}
+
+	ok = db.Transact(func (t *Transaction) {
+		t.RemoveLink("pet", "owner", "rex", "bob")
+		t.AddLink("pet", "owner", "rex", "alice")
+	}, 0)
+	if !ok || db.ReadLinksOne("pet", "owner", "rex")[0] != "alice" {
+		test.Error("Valid owner change failed")
+	}
+}
+
+func TestValidatorIncrements(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("counter", 1, &TestCounter{})
+	def.Validator = func(t *Transaction, key LogeKey, obj interface{}) error {
+		if obj.(*TestCounter).Value > 1 {
+			return errors.New("Too many")
+		}
+		return nil
+	}
+	db.CreateType(def)
+	db.SetOne("counter", "one", &TestCounter{ 0 })
+
+	var t1 = db.CreateTransaction()
+	var t2 = db.CreateTransaction()
+	t1.Increment("counter", "one", "Value", 1)
+	t2.Increment("counter", "one", "Value", 1)
+	if !t1.Commit() {
+		test.Fatal("First increment failed")
+	}
+	if t2.Commit() || t2.GetState() != ABORTED {
+		test.Errorf("Concurrent increment merged: %s", t2.GetState())
+	}
+
+	var ok = db.Transact(func (t *Transaction) {
+		t.Increment("counter", "one", "Value", 1)
+	}, 0)
+	if ok || db.ReadOne("counter", "one").(*TestCounter).Value != 1 {
+		test.Error("Invalid increment committed")
+	}
+}
+
+func TestValidatorWrites(test *testing.T) {
+	var db = NewLogeDB(NewMemStore())
+	var def = NewTypeDef("test", 1, &TestObj{})
+	def.Validator = func(t *Transaction, key LogeKey, obj interface{}) error {
+		t.Set("test", "other", &TestObj{ "Other" })
+		return nil
+	}
+	db.CreateType(def)
+
+	var t = db.CreateTransaction()
+	t.Set("test", "one", &TestObj{ "One" })
+	if t.Commit() || t.Err() == nil {
+		test.Error("Write from validator allowed")
+	}
+	if db.ExistsOne("test", "other") || db.ExistsOne("test", "one") {
+		test.Error("Writing validator committed")
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"time"
 	"runtime"
+	"sync"
 )
 
 const TOTAL = 1000000
 const BATCH_SIZE = 10000
 
+const DURABLE_WRITERS = 32
+const DURABLE_WRITES = 500
+
 func WriteBench() {
 	var cores = runtime.NumCPU()
 	fmt.Printf("Using %d cores\n", cores)
@@ -67,4 +71,45 @@ func WritePeopleBatch(db *loge.LogeDB, start int, end int, tokens chan bool) {
 		}
 	}, 0)
 	tokens<- true
-}
\ No newline at end of file
+}
+
+
+func DurabilityBench() {
+	var cores = runtime.NumCPU()
+	fmt.Printf("Using %d cores\n", cores)
+	runtime.GOMAXPROCS(cores)
+
+	DoDurableWrite(loge.DURABILITY_NONE)
+	DoDurableWrite(loge.DURABILITY_SYNC)
+	DoDurableWrite(loge.DURABILITY_GROUP)
+}
+
+func DoDurableWrite(durability loge.Durability) {
+	var opts = loge.DefaultLevelDBOptions()
+	opts.Durability = durability
+
+	var path = fmt.Sprintf("data/durability_%s", durability)
+	var db = loge.NewLogeDB(loge.NewLevelDBStoreWithOptions(path, opts))
+	defer db.Close()
+	db.CreateType(loge.NewTypeDef("person", 1, &Person{}))
+
+	var startTime = time.Now()
+
+	var group sync.WaitGroup
+	for w := 0; w < DURABLE_WRITERS; w++ {
+		group.Add(1)
+		go func(writer int) {
+			for i := 0; i < DURABLE_WRITES; i++ {
+				var name = fmt.Sprintf("Person %d-%d", writer, i)
+				db.SetOne("person", loge.LogeKey(name), &Person{ Name: name, Age: uint32(i) })
+			}
+			group.Done()
+		}(w)
+	}
+	group.Wait()
+
+	var elapsed = time.Since(startTime)
+	var commits = DURABLE_WRITERS * DURABLE_WRITES
+	fmt.Printf("%s: %d commits in %v (%.0f/s)\n",
+		durability, commits, elapsed, float64(commits) / elapsed.Seconds())
+}
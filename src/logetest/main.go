@@ -16,6 +16,7 @@ func main() {
 	//LinkBench()
 	//LinkSandbox()
 	//WriteBench()
+	//DurabilityBench()
 	//Sandbox()
 	//Example()
 }
\ No newline at end of file
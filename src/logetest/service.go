@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 
@@ -42,6 +43,8 @@ func StartService() {
 	server.AddEndpoint(goservice.NewHttpRpcEndpoint(":6060", server, nil))
 	server.AddEndpoint(goservice.NewTelnetEndpoint(":6061", server))
 
+	go http.ListenAndServe(":6062", db.MetricsHandler())
+
 	server.Log("Server starting...")
 
 	var stopper = make(chan os.Signal, 1)